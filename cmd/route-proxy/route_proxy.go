@@ -1,13 +1,28 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"github.com/seveirbian/edgeserverless/pkg/accesslog"
 	"github.com/seveirbian/edgeserverless/pkg/backend"
+	"github.com/seveirbian/edgeserverless/pkg/breaker"
+	"github.com/seveirbian/edgeserverless/pkg/certs"
 	"github.com/seveirbian/edgeserverless/pkg/entry"
+	"github.com/seveirbian/edgeserverless/pkg/health"
+	"github.com/seveirbian/edgeserverless/pkg/metrics"
+	"github.com/seveirbian/edgeserverless/pkg/tracing"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 
@@ -19,15 +34,38 @@ import (
 )
 
 var (
-	masterURL  string
-	kubeconfig string
-	fnAccessor string
+	masterURL     string
+	kubeconfig    string
+	fnAccessor    string
+	fnAccessorTLS backend.TLSConfig
+	backendConfig string
+	proxyName     string
+	listeners     listenerFlags
+	listenerFile  string
+	metricsAddr   string
+	accessLog     accesslog.Config
+	tracer        tracing.Config
+	timeout       time.Duration
+
+	drainPeriod     time.Duration
+	shutdownTimeout time.Duration
+
+	tlsCertDir         string
+	tlsSecrets         bool
+	tlsSecretNamespace string
+	tlsMinVersion      string
+	tlsCipherSuites    string
+	redirectHTTP       bool
+
+	rateLimitJWTKey string
 )
 
 var (
 	stopCh = signals.SetupSignalHandler()
 
 	RulesManager    *rulesmanager.RulesManager
+	HealthManager   *health.Manager
+	CertStore       *certs.Store
 	Entry           *entry.Entry
 	RouteController *controller.RouteController
 )
@@ -37,12 +75,44 @@ func main() {
 
 	Prepare()
 
-	go Entry.Start()
+	if err := Entry.Start(); err != nil {
+		glog.Fatalf("Error starting entry: %s", err.Error())
+	}
+	if metricsAddr != "" {
+		go serveMetrics()
+	}
 
 	err := RouteController.Run(2, stopCh)
 	if err != nil {
 		glog.Fatalf("Error running controller: %s", err.Error())
 	}
+
+	os.Exit(shutdown())
+}
+
+// shutdown drains the entry once stopCh is closed and flushes the access
+// log and traces. It returns the exit status, 1 when requests were cut.
+func shutdown() int {
+	defer glog.Flush()
+
+	status := 0
+	fmt.Printf("[route-proxy] shutting down, draining for %v\n", drainPeriod)
+	if err := Entry.Shutdown(drainPeriod, shutdownTimeout); err != nil {
+		fmt.Printf("[route-proxy] %v\n", err)
+		status = 1
+	}
+
+	if Entry.AccessLog != nil {
+		Entry.AccessLog.Close()
+	}
+	Entry.Tracer.Shutdown()
+
+	if status == 0 {
+		fmt.Printf("[route-proxy] shut down cleanly\n")
+	} else {
+		fmt.Printf("[route-proxy] shut down with requests cut\n")
+	}
+	return status
 }
 
 func Prepare() {
@@ -52,12 +122,35 @@ func Prepare() {
 	fmt.Printf("[route-proxy] %d initialize rules manager\n", trace)
 	trace++
 	RulesManager = rulesmanager.NewRulesManager()
+	HealthManager = health.NewManager()
+	metrics.RegisterRulesLoaded(RulesManager.Len)
+	metrics.RegisterCircuits(func() []breaker.Status {
+		var statuses []breaker.Status
+		for _, rule := range RulesManager.List() {
+			statuses = append(statuses, rule.Breakers.Status()...)
+		}
+		return statuses
+	})
 
 	// initialize backends
 	fmt.Printf("[route-proxy] %d initialize backends\n", trace)
 	trace++
-	backend.NewK8sServiceBackend()
-	backend.NewYuanrongBackend(fnAccessor)
+	if err := backend.Configure(backendConfig, fnAccessor, fnAccessorTLS); err != nil {
+		glog.Fatalf("Error configuring backends: %s", err.Error())
+	}
+	fmt.Printf("[route-proxy] backends %v of types %v\n", backend.Names(), backend.Types())
+
+	// initialize listeners
+	fmt.Printf("[route-proxy] %d initialize listeners\n", trace)
+	trace++
+	entryListeners, err := resolveListeners()
+	if err != nil {
+		glog.Fatalf("Error configuring listeners: %s", err.Error())
+	}
+	serveHTTPS := false
+	for _, l := range entryListeners {
+		serveHTTPS = serveHTTPS || l.Kind == entry.ListenerHTTPS
+	}
 
 	// initialize route controller
 	fmt.Printf("[route-proxy] %d initialize route controller\n", trace)
@@ -79,20 +172,201 @@ func Prepare() {
 	}
 
 	routeInformerFactory := informers.NewSharedInformerFactory(routeClient, time.Second*30)
+	// only TLS Secrets are cached, of -tlsSecretNamespace when set so the
+	// proxy can be given read access to Secrets of that namespace only
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Second*30,
+		kubeinformers.WithNamespace(tlsSecretNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("type", string(corev1.SecretTypeTLS)).String()
+		}))
+
+	// certificates are only loaded for HTTPS listeners, the Secrets of
+	// routes are not watched without one
+	var secretInformer coreinformers.SecretInformer
+	if serveHTTPS {
+		CertStore = certs.NewStore()
+		if tlsCertDir != "" {
+			if err := CertStore.WatchDir(tlsCertDir, 10*time.Second, stopCh); err != nil {
+				glog.Fatalf("Error loading certificates: %s", err.Error())
+			}
+		}
+		if tlsSecrets {
+			secretInformer = kubeInformerFactory.Core().V1().Secrets()
+		}
+	}
 
 	RouteController = controller.NewRouteController(kubeClient, routeClient,
-		routeInformerFactory.Edgeserverless().V1alpha1().Routes(), RulesManager)
+		routeInformerFactory.Edgeserverless().V1alpha1().Routes(), secretInformer,
+		RulesManager, HealthManager, CertStore, proxyName)
 
 	go routeInformerFactory.Start(stopCh)
+	go kubeInformerFactory.Start(stopCh)
 
 	// initialize entry
 	fmt.Printf("[route-proxy] %d initialize entry\n", trace)
 	trace++
-	Entry = entry.NewEntry(RulesManager)
+	Entry = entry.NewEntry(RulesManager, HealthManager)
+	Entry.DefaultTimeout = timeout
+	Entry.Listeners = entryListeners
+	Entry.Admin = adminHandler()
+	if serveHTTPS {
+		minVersion, err := entry.TLSVersion(tlsMinVersion)
+		if err != nil {
+			glog.Fatalf("Error parsing -tlsMinVersion: %s", err.Error())
+		}
+		cipherSuites, err := entry.CipherSuites(tlsCipherSuites)
+		if err != nil {
+			glog.Fatalf("Error parsing -tlsCipherSuites: %s", err.Error())
+		}
+		Entry.TLSConfig = &tls.Config{MinVersion: minVersion, CipherSuites: cipherSuites}
+		Entry.Certs = CertStore
+		Entry.RedirectHTTP = redirectHTTP
+	}
+	if accessLog.Sink != "" {
+		if Entry.AccessLog, err = accesslog.New(accessLog); err != nil {
+			glog.Fatalf("Error opening access log: %s", err.Error())
+		}
+	}
+	if tracer.Endpoint != "" {
+		if Entry.Tracer, err = tracing.NewTracer(tracer); err != nil {
+			glog.Fatalf("Error creating tracer: %s", err.Error())
+		}
+	}
+	if rateLimitJWTKey != "" {
+		if Entry.JWTVerifier, err = entry.LoadJWTVerifier(rateLimitJWTKey); err != nil {
+			glog.Fatalf("Error loading -rateLimitJWTKey: %s", err.Error())
+		}
+	}
+}
+
+// serveMetrics serves Prometheus metrics on metricsAddr.
+func serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	fmt.Printf("[route-proxy] metrics on %s\n", metricsAddr)
+	if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+		fmt.Printf("[route-proxy] metrics server exit with %v\n", err)
+	}
+}
+
+// adminHandler serves the probes, debug endpoints and metrics on admin
+// listeners.
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/livez", health.Probe{})
+	mux.Handle("/readyz", health.Probe{
+		{Name: "informers-synced", Check: RouteController.Synced},
+		{Name: "backends", Check: backendsRegistered},
+		{Name: "rules-loaded", Check: RouteController.Loaded},
+		{Name: "not-draining", Check: notDraining},
+	})
+	mux.Handle("/debug/health", HealthManager)
+	mux.HandleFunc("/debug/rules", Entry.ServeRules)
+	mux.HandleFunc("/debug/match", Entry.ServeMatch)
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// backendsRegistered returns an error until a backend is registered.
+func backendsRegistered() error {
+	if len(backend.Names()) == 0 {
+		return fmt.Errorf("no backend registered")
+	}
+	return nil
+}
+
+// notDraining returns an error once the entry started draining.
+func notDraining() error {
+	if Entry.Draining() {
+		return fmt.Errorf("entry is draining")
+	}
+	return nil
+}
+
+// resolveListeners returns the listeners of -listenerConfig and -listen, or
+// an HTTP listener on :1122 and an admin listener on 127.0.0.1:1123 when
+// neither is given.
+func resolveListeners() ([]entry.Listener, error) {
+	var resolved []entry.Listener
+	if listenerFile != "" {
+		fromFile, err := entry.LoadListenerFile(listenerFile)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, fromFile...)
+	}
+	resolved = append(resolved, listeners...)
+
+	if len(resolved) == 0 {
+		resolved = []entry.Listener{
+			{Name: entry.ListenerHTTP, Kind: entry.ListenerHTTP, Addr: ":1122"},
+			{Name: entry.ListenerAdmin, Kind: entry.ListenerAdmin, Addr: "127.0.0.1:1123"},
+		}
+	}
+	return resolved, entry.ValidateListeners(resolved)
+}
+
+// listenerFlags collects repeated -listen flags.
+type listenerFlags []entry.Listener
+
+func (f *listenerFlags) String() string {
+	var s []string
+	for _, l := range *f {
+		s = append(s, l.Name+"="+l.Kind+"://"+l.Addr)
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *listenerFlags) Set(value string) error {
+	l, err := entry.ParseListener(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, l)
+	return nil
 }
 
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
-	flag.StringVar(&fnAccessor, "fnAccessor", "", "The address of FnAccessor. Like 192.168.0.1:11111")
+	flag.StringVar(&fnAccessor, "fnAccessor", "", "The address of FnAccessor. Like 192.168.0.1:11111. Ignored when -backendConfig is set.")
+	flag.StringVar(&fnAccessorTLS.CAFile, "fnAccessorCAFile", "", "Path to the CA bundle FnAccessor certificates are verified with. Defaults to the system roots.")
+	flag.StringVar(&fnAccessorTLS.CertFile, "fnAccessorCertFile", "", "Path to the client certificate presented to FnAccessor for mTLS.")
+	flag.StringVar(&fnAccessorTLS.KeyFile, "fnAccessorKeyFile", "", "Path to the key of -fnAccessorCertFile.")
+	flag.StringVar(&fnAccessorTLS.ServerName, "fnAccessorServerName", "", "The server name FnAccessor certificates are verified against. Defaults to the host of -fnAccessor.")
+	flag.BoolVar(&fnAccessorTLS.InsecureSkipVerify, "fnAccessorInsecureSkipVerify", false, "Do not verify FnAccessor certificates. For testing only.")
+	flag.StringVar(&backendConfig, "backendConfig", "", "Path to a backend configuration file. Defaults to a k8sservice backend and a yuanrong backend for -fnAccessor.")
+
+	flag.StringVar(&metricsAddr, "metricsAddr", ":1124", "The address Prometheus metrics are served on at /metrics. Disabled when empty.")
+	flag.Var(&listeners, "listen", "A listener as [name=]kind://addr, kind being http, https or admin and addr a host:port or unix:<path>. Repeatable. Defaults to http://:1122 and admin://127.0.0.1:1123, the admin listener serving /debug/health and /metrics.")
+	flag.StringVar(&listenerFile, "listenerConfig", "", "Path to a file of listeners, in addition to -listen.")
+
+	flag.DurationVar(&timeout, "defaultTimeout", 30*time.Second, "The timeout of requests to routes without one of their own, retries included. None when 0.")
+
+	flag.StringVar(&tlsCertDir, "tlsCertDir", "", "A directory of <name>.crt and <name>.key pairs served on https listeners by SNI. Reloaded on change.")
+	flag.BoolVar(&tlsSecrets, "tlsSecrets", true, "Serve the certificates of the kubernetes.io/tls Secrets referenced by routes in spec.tls on https listeners.")
+	flag.StringVar(&tlsSecretNamespace, "tlsSecretNamespace", "", "Only watch the TLS Secrets of this namespace, so the proxy only needs read access to Secrets there. Routes of other namespaces get no certificate. All namespaces when empty.")
+	flag.StringVar(&tlsMinVersion, "tlsMinVersion", "1.2", "The minimum TLS version of https listeners: 1.0, 1.1, 1.2 or 1.3.")
+	flag.StringVar(&tlsCipherSuites, "tlsCipherSuites", "", "Comma separated cipher suites of https listeners for TLS 1.2 and below. Defaults to the Go defaults.")
+	flag.BoolVar(&redirectHTTP, "redirectHTTP", false, "Redirect requests on http listeners to the first https listener.")
+
+	flag.DurationVar(&drainPeriod, "drainPeriod", 5*time.Second, "How long /readyz of the admin listeners fails on shutdown before listeners close, for load balancers to stop sending traffic.")
+	flag.DurationVar(&shutdownTimeout, "shutdownTimeout", 30*time.Second, "How long requests in flight get to complete on shutdown once listeners close.")
+
+	flag.StringVar(&accessLog.Sink, "accessLog", "stdout", "Where access logs go: stdout, file:<path>, syslog or syslog:<socket path>. Disabled when empty.")
+	flag.StringVar(&accessLog.Format, "accessLogFormat", accesslog.FormatJSON, "The access log format: json, common or combined.")
+	flag.Float64Var(&accessLog.SampleRate, "accessLogSampleRate", 1, "The fraction of requests written to the access log.")
+	flag.IntVar(&accessLog.MaxPerSecond, "accessLogMaxPerSecond", 0, "The most access log lines written per second. Unlimited when 0.")
+	flag.IntVar(&accessLog.MaxSizeMB, "accessLogMaxSizeMB", 100, "The size in megabytes a file access log is rotated at. Never rotated when 0.")
+	flag.IntVar(&accessLog.MaxBackups, "accessLogMaxBackups", 5, "The number of rotated file access logs kept.")
+
+	flag.StringVar(&tracer.Endpoint, "tracingEndpoint", "", "The OTLP/HTTP traces url of a collector, like http://127.0.0.1:4318/v1/traces. Tracing is disabled when empty.")
+	flag.Float64Var(&tracer.SampleRate, "tracingSampleRate", 1, "The fraction of traces started by the proxy that are sampled. Traces started upstream follow their traceparent.")
+	tracer.ServiceName = "route-proxy"
+
+	flag.StringVar(&rateLimitJWTKey, "rateLimitJWTKey", "", "Path to the key verifying the bearer JWTs of rate limits keyed by jwtClaim: a PEM RSA public key for RS256, RS384 and RS512, any other content being an HMAC secret for HS256, HS384 and HS512. Without it those requests share one bucket.")
+
+	hostname, _ := os.Hostname()
+	flag.StringVar(&proxyName, "proxyName", hostname, "The name this proxy reports in Route status. Defaults to the hostname.")
 }
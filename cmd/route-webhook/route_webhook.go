@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+	clientset "github.com/seveirbian/edgeserverless/pkg/client/clientset/versioned"
+	informers "github.com/seveirbian/edgeserverless/pkg/client/informers/externalversions"
+	"github.com/seveirbian/edgeserverless/pkg/signals"
+	"github.com/seveirbian/edgeserverless/pkg/webhook"
+)
+
+var (
+	masterURL     string
+	kubeconfig    string
+	fnAccessor    string
+	fnAccessorTLS backend.TLSConfig
+	backendConfig string
+
+	addr         string
+	certFile     string
+	keyFile      string
+	selfSigned   bool
+	certHosts    string
+	caBundleFile string
+)
+
+func main() {
+	flag.Parse()
+
+	stopCh := signals.SetupSignalHandler()
+
+	// backends are only configured so that route targets can be checked
+	// against them, the webhook never invokes them
+	if err := backend.Configure(backendConfig, fnAccessor, fnAccessorTLS); err != nil {
+		glog.Fatalf("Error configuring backends: %s", err.Error())
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
+	if err != nil {
+		glog.Fatalf("Error building kubeconfig: %s", err.Error())
+	}
+
+	routeClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("Error building route clientset: %s", err.Error())
+	}
+
+	routeInformerFactory := informers.NewSharedInformerFactory(routeClient, time.Second*30)
+	routeInformer := routeInformerFactory.Edgeserverless().V1alpha1().Routes()
+	validator := webhook.NewRouteValidator(routeInformer.Lister())
+
+	go routeInformerFactory.Start(stopCh)
+	if ok := cache.WaitForCacheSync(stopCh, routeInformer.Informer().HasSynced); !ok {
+		glog.Fatalf("failed to wait for caches to sync")
+	}
+
+	cert, err := loadCert()
+	if err != nil {
+		glog.Fatalf("Error loading serving certificate: %s", err.Error())
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(webhook.ValidatePath, validator)
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+
+	go func() {
+		<-stopCh
+		server.Close()
+	}()
+
+	fmt.Printf("[route-webhook] serving %s on %s\n", webhook.ValidatePath, addr)
+	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		glog.Fatalf("Error serving webhook: %s", err.Error())
+	}
+}
+
+func loadCert() (tls.Certificate, error) {
+	if !selfSigned {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	cert, caBundle, err := webhook.SelfSignedCert(strings.Split(certHosts, ","), 365*24*time.Hour)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if caBundleFile != "" {
+		if err := ioutil.WriteFile(caBundleFile, caBundle, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		fmt.Printf("[route-webhook] wrote self-signed CA bundle to %s\n", caBundleFile)
+	} else {
+		fmt.Printf("[route-webhook] self-signed CA bundle:\n%s", caBundle)
+	}
+
+	return cert, nil
+}
+
+func init() {
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&fnAccessor, "fnAccessor", "", "The address of FnAccessor, as given to route-proxy. Ignored when -backendConfig is set.")
+	flag.StringVar(&fnAccessorTLS.CAFile, "fnAccessorCAFile", "", "Path to the CA bundle FnAccessor certificates are verified with. Defaults to the system roots.")
+	flag.StringVar(&fnAccessorTLS.CertFile, "fnAccessorCertFile", "", "Path to the client certificate presented to FnAccessor for mTLS.")
+	flag.StringVar(&fnAccessorTLS.KeyFile, "fnAccessorKeyFile", "", "Path to the key of -fnAccessorCertFile.")
+	flag.StringVar(&fnAccessorTLS.ServerName, "fnAccessorServerName", "", "The server name FnAccessor certificates are verified against. Defaults to the host of -fnAccessor.")
+	flag.BoolVar(&fnAccessorTLS.InsecureSkipVerify, "fnAccessorInsecureSkipVerify", false, "Do not verify FnAccessor certificates. For testing only.")
+	flag.StringVar(&backendConfig, "backendConfig", "", "Path to the backend configuration file used by route-proxy.")
+	flag.StringVar(&addr, "addr", ":8443", "The address the webhook server listens on.")
+	flag.StringVar(&certFile, "tlsCertFile", "", "Path to the serving certificate.")
+	flag.StringVar(&keyFile, "tlsKeyFile", "", "Path to the serving certificate key.")
+	flag.BoolVar(&selfSigned, "selfSigned", false, "Generate a self-signed serving certificate instead of loading one. For local testing only.")
+	flag.StringVar(&certHosts, "certHosts", "route-webhook.edgeserverless-system.svc,localhost,127.0.0.1", "Comma separated hosts the self-signed certificate is valid for.")
+	flag.StringVar(&caBundleFile, "caBundleFile", "", "Where to write the self-signed CA bundle. Printed to stdout when empty.")
+}
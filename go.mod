@@ -10,11 +10,13 @@ require (
 	github.com/google/gofuzz v1.2.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/mroth/weightedrand v0.4.1
+	github.com/prometheus/client_golang v1.12.1
 	github.com/valyala/fasthttp v1.29.0
-	golang.org/x/net v0.0.0-20211015210444-4f30a5c0130f // indirect
+	golang.org/x/net v0.0.0-20211015210444-4f30a5c0130f
 	golang.org/x/text v0.3.7 // indirect
 	k8s.io/api v0.22.2
 	k8s.io/apimachinery v0.22.2
 	k8s.io/client-go v0.22.2
 	k8s.io/klog/v2 v2.20.0 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,335 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Formats of access log lines.
+const (
+	FormatJSON     = "json"
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+)
+
+// Record is one access log entry.
+type Record struct {
+	Time            time.Time     `json:"time"`
+	RequestID       string        `json:"requestId"`
+	ClientIP        string        `json:"clientIp"`
+	Method          string        `json:"method"`
+	Host            string        `json:"host"`
+	Path            string        `json:"path"`
+	Protocol        string        `json:"protocol"`
+	Status          int           `json:"status"`
+	BytesIn         int           `json:"bytesIn"`
+	BytesOut        int           `json:"bytesOut"`
+	Route           string        `json:"route,omitempty"`
+	Target          string        `json:"target,omitempty"`
+	Backend         string        `json:"backend,omitempty"`
+	Attempts        int           `json:"attempts,omitempty"`
+	Duration        time.Duration `json:"-"`
+	UpstreamLatency time.Duration `json:"-"`
+	Referer         string        `json:"referer,omitempty"`
+	UserAgent       string        `json:"userAgent,omitempty"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// Config configures a Logger.
+type Config struct {
+	// Sink is "stdout", "file:<path>", "syslog" or "syslog:<socket path>".
+	Sink   string
+	Format string
+	// SampleRate is the fraction of requests logged, in (0, 1].
+	SampleRate float64
+	// MaxPerSecond caps the lines written per second, unlimited when 0.
+	MaxPerSecond int
+	// MaxSizeMB and MaxBackups configure rotation of file sinks.
+	MaxSizeMB  int
+	MaxBackups int
+	// BufferSize is the number of records queued before new ones are dropped.
+	BufferSize int
+}
+
+// Logger writes access log records asynchronously. Records are dropped
+// rather than blocking requests when the sink can not keep up, and once the
+// Logger is closed.
+type Logger struct {
+	config Config
+	out    io.WriteCloser
+	format func(*bytes.Buffer, *Record)
+
+	// records is never closed, requests still in flight after Close may
+	// log
+	records chan *Record
+	stop    chan struct{}
+	done    chan struct{}
+
+	second  int64
+	written int64
+	dropped uint64
+}
+
+func New(config Config) (*Logger, error) {
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		config.SampleRate = 1
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 4096
+	}
+
+	l := &Logger{
+		config:  config,
+		records: make(chan *Record, config.BufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	switch config.Format {
+	case "", FormatJSON:
+		l.format = formatJSON
+	case FormatCommon:
+		l.format = formatCommon
+	case FormatCombined:
+		l.format = formatCombined
+	default:
+		return nil, fmt.Errorf("[accesslog] unknown format %s", config.Format)
+	}
+
+	out, err := openSink(config)
+	if err != nil {
+		return nil, err
+	}
+	l.out = out
+
+	go l.run()
+	return l, nil
+}
+
+// Log queues r unless it is sampled out, over the rate cap, or the queue is
+// full.
+func (l *Logger) Log(r *Record) {
+	select {
+	case <-l.stop:
+		atomic.AddUint64(&l.dropped, 1)
+		return
+	default:
+	}
+	if l.config.SampleRate < 1 && rand.Float64() >= l.config.SampleRate {
+		return
+	}
+	if !l.allow(r.Time) {
+		atomic.AddUint64(&l.dropped, 1)
+		return
+	}
+
+	select {
+	case l.records <- r:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped so far.
+func (l *Logger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close flushes queued records and closes the sink. Records logged later are
+// dropped.
+func (l *Logger) Close() error {
+	close(l.stop)
+	<-l.done
+	return l.out.Close()
+}
+
+func (l *Logger) allow(now time.Time) bool {
+	if l.config.MaxPerSecond <= 0 {
+		return true
+	}
+
+	sec := now.Unix()
+	if old := atomic.LoadInt64(&l.second); old != sec && atomic.CompareAndSwapInt64(&l.second, old, sec) {
+		atomic.StoreInt64(&l.written, 0)
+	}
+	return atomic.AddInt64(&l.written, 1) <= int64(l.config.MaxPerSecond)
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+
+	buf := &bytes.Buffer{}
+	write := func(r *Record) {
+		buf.Reset()
+		l.format(buf, r)
+		buf.WriteByte('\n')
+		if _, err := l.out.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "[accesslog] write error: %v\n", err)
+		}
+	}
+
+	for {
+		select {
+		case r := <-l.records:
+			write(r)
+		case <-l.stop:
+			for {
+				select {
+				case r := <-l.records:
+					write(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func openSink(config Config) (io.WriteCloser, error) {
+	switch {
+	case config.Sink == "" || config.Sink == "stdout":
+		return nopCloser{os.Stdout}, nil
+	case strings.HasPrefix(config.Sink, "file:"):
+		return newRotatingFile(strings.TrimPrefix(config.Sink, "file:"), config.MaxSizeMB, config.MaxBackups)
+	case config.Sink == "syslog":
+		return syslog.New(syslog.LOG_INFO|syslog.LOG_LOCAL0, "route-proxy")
+	case strings.HasPrefix(config.Sink, "syslog:"):
+		return syslog.Dial("unixgram", strings.TrimPrefix(config.Sink, "syslog:"),
+			syslog.LOG_INFO|syslog.LOG_LOCAL0, "route-proxy")
+	}
+	return nil, fmt.Errorf("[accesslog] unknown sink %s", config.Sink)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func formatJSON(buf *bytes.Buffer, r *Record) {
+	type record Record
+	json.NewEncoder(buf).Encode(struct {
+		*record
+		DurationMs        float64 `json:"durationMs"`
+		UpstreamLatencyMs float64 `json:"upstreamLatencyMs"`
+	}{
+		record:            (*record)(r),
+		DurationMs:        float64(r.Duration) / float64(time.Millisecond),
+		UpstreamLatencyMs: float64(r.UpstreamLatency) / float64(time.Millisecond),
+	})
+	// Encode ends with a newline, run adds its own
+	buf.Truncate(buf.Len() - 1)
+}
+
+// formatCommon writes the Common Log Format, with the request id as user.
+func formatCommon(buf *bytes.Buffer, r *Record) {
+	fmt.Fprintf(buf, "%s - %s [%s] \"%s %s %s\" %d %d",
+		dash(r.ClientIP), dash(r.RequestID), r.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.Path, r.Protocol, r.Status, r.BytesOut)
+}
+
+// formatCombined writes the Combined Log Format followed by the route,
+// target and upstream latency in milliseconds.
+func formatCombined(buf *bytes.Buffer, r *Record) {
+	formatCommon(buf, r)
+	fmt.Fprintf(buf, " %q %q %s %s %.3f", r.Referer, r.UserAgent,
+		dash(r.Route), dash(r.Target), float64(r.UpstreamLatency)/float64(time.Millisecond))
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// rotatingFile is a file that is renamed to path.1, path.2, ... once it
+// grows over maxSize.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSizeMB int, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(f.path, 0); err != nil {
+		return err
+	}
+
+	return f.open()
+}
@@ -0,0 +1,36 @@
+package accesslog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestClose checks that Close writes the queued records and that records
+// logged later, by requests still in flight, are dropped.
+func TestClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := New(Config{Sink: "file:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Log(&Record{Time: time.Now(), Path: "/queued"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l.Log(&Record{Time: time.Now(), Path: "/late"})
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 1 {
+		t.Errorf("got %d lines, want 1: %s", lines, data)
+	}
+	if dropped := l.Dropped(); dropped != 1 {
+		t.Errorf("got %d records dropped, want 1", dropped)
+	}
+}
@@ -0,0 +1,11 @@
+package v1alpha1
+
+// AllTargets returns the default targets of the route followed by those of
+// each match.
+func (in *RouteSpec) AllTargets() []RouteTarget {
+	targets := append([]RouteTarget{}, in.Targets...)
+	for _, m := range in.Matches {
+		targets = append(targets, m.Targets...)
+	}
+	return targets
+}
@@ -5,7 +5,6 @@ import (
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 type Route struct {
@@ -13,21 +12,377 @@ type Route struct {
 	// +optional
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 	Spec              RouteSpec `json:"spec"`
+	// +optional
+	Status RouteStatus `json:"status,omitempty"`
 }
 
+// Match types for RouteSpec.MatchType. Exact and prefix uris may use a
+// leading "*." host wildcard and ":name" path segments; regex uris are
+// matched against host+path and expose named groups as parameters.
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchRegex  = "regex"
+)
+
 type RouteSpec struct {
-	ID      string        `json:"id,omitempty"`
-	Name    string        `json:"name"`
-	URI     string        `json:"uri"`
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+	// +optional
+	MatchType string `json:"matchType,omitempty"`
+	// Methods limits the HTTP methods served by the route, all when empty.
+	// +optional
+	Methods []string `json:"methods,omitempty"`
+	// Listeners names the listeners of the proxy serving the route, so
+	// internal routes can be kept off public listeners. All listeners serve
+	// it when empty.
+	// +optional
+	Listeners []string `json:"listeners,omitempty"`
+	// Matches are evaluated in order and the first one whose predicates all
+	// hold selects its targets. Targets is used when none of them hold.
+	// +optional
+	Matches []RouteMatch `json:"matches,omitempty"`
+	// +optional
+	Targets []RouteTarget `json:"targets,omitempty"`
+	// +optional
+	SessionAffinity *SessionAffinity `json:"sessionAffinity,omitempty"`
+	// Rewrite sets the path forwarded to k8sservice targets, the request
+	// path is forwarded unchanged when unset.
+	// +optional
+	Rewrite *PathRewrite `json:"rewrite,omitempty"`
+	// +optional
+	Headers *Headers `json:"headers,omitempty"`
+	// Timeout bounds the whole request, retries included. It defaults to
+	// the -defaultTimeout of the proxy.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// IdleTimeout is the longest the connection to a target may go without
+	// reading or writing while a call is made. A call idle for longer is
+	// abandoned, and retried when the retry policy allows it.
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+	// TLS names the certificate served for the host of the route on the
+	// HTTPS listener of the proxy.
+	// +optional
+	TLS *RouteTLS `json:"tls,omitempty"`
+	// +optional
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// +optional
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+	// +optional
+	CircuitBreaker *CircuitBreaker `json:"circuitBreaker,omitempty"`
+	// +optional
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+}
+
+// Keys for SessionAffinity.Key.
+const (
+	AffinityByHeader   = "header"
+	AffinityByCookie   = "cookie"
+	AffinityByClientIP = "clientIP"
+	AffinityByQuery    = "query"
+)
+
+// SessionAffinity sends the requests of one user to the same target, instead
+// of picking one at random by ratio for each request. A user keeps its
+// target as long as it is available.
+type SessionAffinity struct {
+	// Key hashes requests onto a ring of the targets weighted by ratio:
+	// header, cookie or query for the value Name, or clientIP. Requests
+	// without the value are spread by ratio at random.
+	// +optional
+	Key string `json:"key,omitempty"`
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Cookie has the proxy issue a cookie naming the target that served a
+	// user, later requests with the cookie go to that target. At least one
+	// of Key and Cookie must be set.
+	// +optional
+	Cookie *AffinityCookie `json:"cookie,omitempty"`
+}
+
+// AffinityCookie is the cookie naming the target of a user.
+type AffinityCookie struct {
+	Name string `json:"name"`
+	// TTL sets the Max-Age of the cookie, it lasts for the browser session
+	// when unset.
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+	// Path defaults to /.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// Path rewrites for PathRewrite.Type.
+const (
+	RewritePass          = "pass"
+	RewriteStripPrefix   = "stripPrefix"
+	RewriteReplacePrefix = "replacePrefix"
+	RewriteRegex         = "regex"
+)
+
+// PathRewrite rewrites the path of requests forwarded to k8sservice
+// targets, which is appended to the target url. The query string is always
+// forwarded as is.
+type PathRewrite struct {
+	// Type is pass to forward the path unchanged, stripPrefix to remove the
+	// path of the uri from it, replacePrefix to replace the path of the uri
+	// with Prefix, or regex to replace the matches of Regex with
+	// Replacement. stripPrefix and replacePrefix need an exact or prefix
+	// uri. It defaults to pass.
+	// +optional
+	Type string `json:"type,omitempty"`
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// +optional
+	Regex string `json:"regex,omitempty"`
+	// Replacement may refer to the groups of Regex as $1 or ${name}.
+	// +optional
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// Headers changes the headers of requests sent to the targets of a route
+// and of the responses they return. Values may refer to the variables
+// client_ip, host, method, path, scheme, request_id, route, uri, target and
+// backend as ${name}.
+type Headers struct {
+	// +optional
+	Request *HeaderRules `json:"request,omitempty"`
+	// +optional
+	Response *HeaderRules `json:"response,omitempty"`
+}
+
+// HeaderRules remove, set and then add headers, in that order.
+type HeaderRules struct {
+	// +optional
+	Remove []string `json:"remove,omitempty"`
+	// Set replaces any value of the header.
+	// +optional
+	Set []HeaderValue `json:"set,omitempty"`
+	// Add appends a value to the header.
+	// +optional
+	Add []HeaderValue `json:"add,omitempty"`
+}
+
+type HeaderValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// RouteTLS references a kubernetes.io/tls Secret in the namespace of the
+// route. The certificate is selected by SNI, and only served for the host
+// of the route.
+type RouteTLS struct {
+	SecretName string `json:"secretName"`
+}
+
+// HealthCheck configures active probing of k8sservice targets and passive
+// ejection of any target after consecutive failed requests. Unhealthy
+// targets are skipped and their ratio goes to the remaining ones.
+type HealthCheck struct {
+	// Path is probed with GET on k8sservice targets. Probing is disabled
+	// when empty.
+	// +optional
+	Path string `json:"path,omitempty"`
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// HealthyThreshold is the number of passed probes to mark a target
+	// healthy again.
+	// +optional
+	HealthyThreshold int32 `json:"healthyThreshold,omitempty"`
+	// UnhealthyThreshold is the number of failed probes to mark a target
+	// unhealthy.
+	// +optional
+	UnhealthyThreshold int32 `json:"unhealthyThreshold,omitempty"`
+	// ConsecutiveFailures ejects a target after that many requests in a row
+	// failed to connect or returned 5xx. Passive ejection is disabled when 0.
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+	// +optional
+	EjectionTime *metav1.Duration `json:"ejectionTime,omitempty"`
+}
+
+// Conditions for RetryPolicy.RetryOn.
+const (
+	RetryOnConnectFailure = "connect-failure"
+	RetryOnTimeout        = "timeout"
+	RetryOn5xx            = "5xx"
+	RetryOn429            = "429"
+)
+
+// RetryPolicy retries failed requests on the other targets of a route.
+type RetryPolicy struct {
+	// Attempts is the maximum number of tries, including the first one.
+	Attempts int32 `json:"attempts"`
+	// RetryOn lists the failures that are retried, connect-failure when empty.
+	// +optional
+	RetryOn []string `json:"retryOn,omitempty"`
+	// PerTryTimeout bounds each try. Tries still end with the timeout of the
+	// route, and the backend timeout applies when neither is set.
+	// +optional
+	PerTryTimeout *metav1.Duration `json:"perTryTimeout,omitempty"`
+	// Backoff is the delay before the first retry, doubled for each next one
+	// up to MaxBackoff.
+	// +optional
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+	// +optional
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+	// RetryNonIdempotent allows retrying requests with methods such as POST
+	// after they may have reached the target. Connect failures are always
+	// retried.
+	// +optional
+	RetryNonIdempotent bool `json:"retryNonIdempotent,omitempty"`
+}
+
+// Keys for RateLimit.Key.
+const (
+	RateLimitByRoute    = "route"
+	RateLimitByClientIP = "clientIP"
+	RateLimitByHeader   = "header"
+	RateLimitByJWTClaim = "jwtClaim"
+)
+
+// RateLimit limits the requests of a route with a token bucket per key.
+// Requests over the limit are answered with 429 and not sent to any target.
+type RateLimit struct {
+	// RequestsPerSecond is the rate the buckets refill at.
+	RequestsPerSecond int32 `json:"requestsPerSecond"`
+	// Burst is the size of the buckets, RequestsPerSecond when 0.
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+	// Key selects the bucket of a request: route for a single bucket,
+	// clientIP, header or jwtClaim. Requests lacking the header or claim
+	// share one bucket. It defaults to route.
+	// +optional
+	Key string `json:"key,omitempty"`
+	// Name is the header, or the claim of the bearer JWT in the
+	// Authorization header, keying requests. JWTs are verified with the
+	// -rateLimitJWTKey of the proxy; requests with no valid token, or all
+	// of them when the proxy has no key, share one bucket.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// CircuitBreaker limits the requests to each target of a route and stops
+// sending requests to targets that keep failing. Targets that are open or
+// full are skipped and their ratio goes to the others, requests get 503
+// when none is left.
+type CircuitBreaker struct {
+	// MaxRequests bounds the requests in flight to one target, unlimited
+	// when 0.
+	// +optional
+	MaxRequests int32 `json:"maxRequests,omitempty"`
+	// MaxPending bounds the requests waiting for one of MaxRequests to
+	// complete, none wait when 0.
+	// +optional
+	MaxPending int32 `json:"maxPending,omitempty"`
+	// PendingTimeout bounds the wait of pending requests, 1s by default.
+	// +optional
+	PendingTimeout *metav1.Duration `json:"pendingTimeout,omitempty"`
+	// ErrorRate is the percentage of failed requests to a target within
+	// Window that opens its breaker. Breakers never open when 0. Requests
+	// fail when they can not connect or get 5xx.
+	// +optional
+	ErrorRate int32 `json:"errorRate,omitempty"`
+	// SlowRequest counts requests taking longer as failed.
+	// +optional
+	SlowRequest *metav1.Duration `json:"slowRequest,omitempty"`
+	// MinRequests is the number of requests within Window before the error
+	// rate is considered, 10 by default.
+	// +optional
+	MinRequests int32 `json:"minRequests,omitempty"`
+	// Window is the period the error rate is measured over, 10s by default.
+	// +optional
+	Window *metav1.Duration `json:"window,omitempty"`
+	// OpenTime is how long an open breaker short-circuits its target before
+	// letting HalfOpenRequests through, 30s by default.
+	// +optional
+	OpenTime *metav1.Duration `json:"openTime,omitempty"`
+	// HalfOpenRequests are let through after OpenTime, the breaker closes
+	// when they all succeed and opens again otherwise. 1 by default.
+	// +optional
+	HalfOpenRequests int32 `json:"halfOpenRequests,omitempty"`
+}
+
+type RouteMatch struct {
+	// +optional
+	Methods []string `json:"methods,omitempty"`
+	// +optional
+	Headers []ValueMatch `json:"headers,omitempty"`
+	// +optional
+	Queries []ValueMatch `json:"queries,omitempty"`
+	// +optional
+	Cookies []ValueMatch  `json:"cookies,omitempty"`
 	Targets []RouteTarget `json:"targets"`
 }
 
+// ValueMatch holds when the named value is present and, if given, equals
+// Value or matches Regex.
+type ValueMatch struct {
+	Name string `json:"name"`
+	// +optional
+	Value string `json:"value,omitempty"`
+	// +optional
+	Regex string `json:"regex,omitempty"`
+}
+
 type RouteTarget struct {
 	Target string `json:"target"`
 	Type   string `json:"type"`
 	Ratio  int64  `json:"ratio"`
 }
 
+// Condition types reported in RouteStatus.Conditions. They are the same on
+// every proxy.
+const (
+	// RouteReady means the route is valid and owns its uri, so proxies load
+	// it. Proxies tells which of them did.
+	RouteReady = "Ready"
+	// RouteInvalid means the route spec can not be served as written.
+	RouteInvalid = "Invalid"
+	// RouteConflicted means an older route already claims the same uri.
+	RouteConflicted = "Conflicted"
+)
+
+// Condition types reported in ProxyStatus.Conditions, by each proxy for
+// itself.
+const (
+	// RouteLoaded means the proxy loaded the route into its rules table.
+	RouteLoaded = "Loaded"
+	// RouteBackendUnavailable means a target refers to a backend that is not
+	// configured in the proxy.
+	RouteBackendUnavailable = "BackendUnavailable"
+	// RouteTargetsHealthy means every target passes the health checks of
+	// the proxy. Its message counts the unhealthy and ejected ones.
+	RouteTargetsHealthy = "TargetsHealthy"
+)
+
+type RouteStatus struct {
+	// ObservedGeneration is the most recent generation synced by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Proxies are the route-proxy instances serving the route while it owns
+	// its uri, by name. Each proxy writes its own entry only, backends being
+	// configured per proxy.
+	// +optional
+	Proxies []ProxyStatus `json:"proxies,omitempty"`
+}
+
+// ProxyStatus is the state of a route on one route-proxy instance.
+type ProxyStatus struct {
+	Name string `json:"name"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastSyncTime is when the proxy last changed its entry.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 type RouteList struct {
@@ -3,12 +3,24 @@ package backend
 import (
 	"fmt"
 	"github.com/valyala/fasthttp"
+	"time"
 )
 
 var Backends = map[string]Backend{}
 
 type Backend interface {
-	Invoke(string, *fasthttp.Request, *fasthttp.Response) error
+	// Invoke sends req to target and fills res. A positive timeout overrides
+	// the timeout the backend was configured with. A positive idleTimeout
+	// fails the call when the upstream connection sees no read or write
+	// progress for that long.
+	Invoke(target string, req *fasthttp.Request, res *fasthttp.Response,
+		timeout time.Duration, idleTimeout time.Duration) error
+}
+
+// TargetValidator is implemented by backends that can check a RouteTarget
+// target string before it is served.
+type TargetValidator interface {
+	ValidateTarget(string) error
 }
 
 func AddBackend(name string, backend Backend) {
@@ -23,3 +35,21 @@ func GetBackend(name string) (Backend, error) {
 
 	return bke, nil
 }
+
+// Prober is implemented by backends whose targets can be health checked
+// actively by the health manager.
+type Prober interface {
+	Probe(target string, path string, timeout time.Duration) error
+}
+
+// PoolStats describes the connection pools of a backend.
+type PoolStats struct {
+	// Conns is the number of open connections by upstream address.
+	Conns map[string]int
+}
+
+// PoolStatser is implemented by backends that keep connection pools, so
+// their usage can be exported as metrics.
+type PoolStatser interface {
+	PoolStats() PoolStats
+}
@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The benchmarks compare the long-lived pooled clients of the backends with
+// a client created for every call, as YuanrongBackend used to do. Run them
+// with
+//
+//	go test -run '^$' -bench . ./pkg/backend
+
+func newUpstream(tls bool) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	if tls {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+func newBenchYuanrong(b *testing.B, server *httptest.Server) *YuanrongBackend {
+	bke, err := newYuanrongBackend(Config{
+		Name:      YuanrongBackendType,
+		Type:      YuanrongBackendType,
+		Endpoints: []string{strings.TrimPrefix(server.URL, "https://")},
+		TLS:       TLSConfig{InsecureSkipVerify: true},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return bke.(*YuanrongBackend)
+}
+
+// invokeUnpooled calls the function the way YuanrongBackend did before it
+// kept a client, paying a TCP and TLS handshake on every call.
+func invokeUnpooled(y *YuanrongBackend, target string, req *fasthttp.Request, res *fasthttp.Response) error {
+	req.SetRequestURI("https://" + y.server() + "/serverless/v1/functions/" + target + "/invocations")
+	client := fasthttp.Client{
+		TLSConfig: y.client.get(0).TLSConfig,
+	}
+	return client.DoTimeout(req, res, time.Second)
+}
+
+func benchmarkInvoke(b *testing.B, invoke func(req *fasthttp.Request, res *fasthttp.Response) error) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		req := fasthttp.AcquireRequest()
+		res := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(res)
+
+		for pb.Next() {
+			req.Header.SetMethod(fasthttp.MethodPost)
+			req.SetBodyString(`{"hello":"world"}`)
+			if err := invoke(req, res); err != nil {
+				b.Fatal(err)
+			}
+			if res.StatusCode() != fasthttp.StatusOK {
+				b.Fatalf("unexpected status %d", res.StatusCode())
+			}
+		}
+	})
+}
+
+func BenchmarkYuanrongInvokeUnpooled(b *testing.B) {
+	server := newUpstream(true)
+	defer server.Close()
+	y := newBenchYuanrong(b, server)
+
+	benchmarkInvoke(b, func(req *fasthttp.Request, res *fasthttp.Response) error {
+		return invokeUnpooled(y, "hello", req, res)
+	})
+}
+
+func BenchmarkYuanrongInvoke(b *testing.B) {
+	server := newUpstream(true)
+	defer server.Close()
+	y := newBenchYuanrong(b, server)
+
+	benchmarkInvoke(b, func(req *fasthttp.Request, res *fasthttp.Response) error {
+		return y.Invoke("hello", req, res, time.Second, 0)
+	})
+	for _, conns := range y.PoolStats().Conns {
+		b.ReportMetric(float64(conns), "conns")
+	}
+}
+
+func BenchmarkK8sServiceInvoke(b *testing.B) {
+	server := newUpstream(false)
+	defer server.Close()
+	bke, err := newK8sServiceBackend(Config{Name: K8sServiceBackendType, Type: K8sServiceBackendType})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkInvoke(b, func(req *fasthttp.Request, res *fasthttp.Response) error {
+		return bke.Invoke(server.URL+"/hello", req, res, time.Second, 0)
+	})
+}
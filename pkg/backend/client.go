@@ -0,0 +1,203 @@
+package backend
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PoolConfig tunes the connection pool of the client a backend keeps for its
+// whole life. Zero values keep the fasthttp defaults.
+type PoolConfig struct {
+	// MaxConnsPerHost bounds the open connections to each upstream address.
+	// +optional
+	MaxConnsPerHost int `json:"maxConnsPerHost,omitempty"`
+	// MaxConnWaitTimeout is how long a call waits for a free connection once
+	// MaxConnsPerHost is reached, instead of failing at once.
+	// +optional
+	MaxConnWaitTimeout metav1.Duration `json:"maxConnWaitTimeout,omitempty"`
+	// MaxIdleConnDuration closes keep-alive connections idle for longer.
+	// +optional
+	MaxIdleConnDuration metav1.Duration `json:"maxIdleConnDuration,omitempty"`
+	// MaxConnDuration closes keep-alive connections older than this.
+	// +optional
+	MaxConnDuration metav1.Duration `json:"maxConnDuration,omitempty"`
+	// +optional
+	ReadBufferSize int `json:"readBufferSize,omitempty"`
+	// +optional
+	WriteBufferSize int `json:"writeBufferSize,omitempty"`
+}
+
+// pooledClient is the long-lived client of a backend. It keeps a client per
+// idle timeout calls are made with, as the timeout applies to the
+// connections of the client. They are replaced when the TLS files of the
+// backend change, so new connections use the new certificates while those
+// of the old clients drain as they go idle.
+type pooledClient struct {
+	pool  PoolConfig
+	conns connCounter
+
+	mu        sync.RWMutex
+	tlsConfig *tls.Config
+	clients   map[time.Duration]*fasthttp.Client
+}
+
+func newPooledClient(config Config) (*pooledClient, error) {
+	tlsConfig, err := newTLSConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if config.TLS.InsecureSkipVerify {
+		fmt.Printf("[backend] WARNING backend %s does not verify upstream certificates\n", config.Name)
+	}
+
+	c := &pooledClient{pool: config.Pool, tlsConfig: tlsConfig, clients: map[time.Duration]*fasthttp.Client{}}
+	watchTLS(config.Name, config.TLS, func(tlsConfig *tls.Config) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.tlsConfig = tlsConfig
+		c.clients = map[time.Duration]*fasthttp.Client{}
+	})
+
+	return c, nil
+}
+
+// newClient creates a client sending requests as the entry received them,
+// without a default User-Agent or path normalization. Its connections fail
+// reads and writes that make no progress for idleTimeout, when positive.
+func (c *pooledClient) newClient(tlsConfig *tls.Config, idleTimeout time.Duration) *fasthttp.Client {
+	dial := c.conns.dial
+	if idleTimeout > 0 {
+		dial = func(addr string) (net.Conn, error) {
+			conn, err := c.conns.dial(addr)
+			if err != nil {
+				return nil, err
+			}
+			return &idleConn{Conn: conn, timeout: idleTimeout}, nil
+		}
+	}
+	return &fasthttp.Client{
+		NoDefaultUserAgentHeader: true,
+		DisablePathNormalizing:   true,
+		TLSConfig:                tlsConfig,
+		Dial:                     dial,
+		MaxConnsPerHost:          c.pool.MaxConnsPerHost,
+		MaxConnWaitTimeout:       c.pool.MaxConnWaitTimeout.Duration,
+		MaxIdleConnDuration:      c.pool.MaxIdleConnDuration.Duration,
+		MaxConnDuration:          c.pool.MaxConnDuration.Duration,
+		ReadBufferSize:           c.pool.ReadBufferSize,
+		WriteBufferSize:          c.pool.WriteBufferSize,
+	}
+}
+
+// get returns the client for calls with idleTimeout, creating it on first
+// use.
+func (c *pooledClient) get(idleTimeout time.Duration) *fasthttp.Client {
+	if idleTimeout < 0 {
+		idleTimeout = 0
+	}
+	c.mu.RLock()
+	client, ok := c.clients[idleTimeout]
+	c.mu.RUnlock()
+	if ok {
+		return client
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[idleTimeout]; ok {
+		return client
+	}
+	client = c.newClient(c.tlsConfig, idleTimeout)
+	c.clients[idleTimeout] = client
+	return client
+}
+
+// DoTimeout sends req within timeout, failing when the connection is idle
+// for idleTimeout, if positive, while sending it or reading res.
+func (c *pooledClient) DoTimeout(req *fasthttp.Request, res *fasthttp.Response,
+	timeout time.Duration, idleTimeout time.Duration) error {
+	return c.get(idleTimeout).DoTimeout(req, res, timeout)
+}
+
+func (c *pooledClient) stats() PoolStats {
+	return c.conns.stats()
+}
+
+// connCounter counts the open connections of a client by address, for
+// PoolStats.
+type connCounter struct {
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func (c *connCounter) dial(addr string) (net.Conn, error) {
+	conn, err := fasthttp.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.add(addr, 1)
+	return &countedConn{Conn: conn, closed: func() { c.add(addr, -1) }}, nil
+}
+
+func (c *connCounter) add(addr string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conns == nil {
+		c.conns = map[string]int{}
+	}
+	c.conns[addr] += n
+	if c.conns[addr] == 0 {
+		delete(c.conns, addr)
+	}
+}
+
+func (c *connCounter) stats() PoolStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := PoolStats{Conns: make(map[string]int, len(c.conns))}
+	for addr, n := range c.conns {
+		stats.Conns[addr] = n
+	}
+	return stats
+}
+
+type countedConn struct {
+	net.Conn
+	once   sync.Once
+	closed func()
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(c.closed)
+	return c.Conn.Close()
+}
+
+// idleConn fails a read or write that makes no progress for timeout, the
+// deadline is pushed back before each of them.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
@@ -1,17 +1,93 @@
 package backend
 
-import "github.com/valyala/fasthttp"
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
-const k8sServiceBackendName = "k8sservice"
+	"github.com/valyala/fasthttp"
+)
+
+const K8sServiceBackendType = "k8sservice"
+
+func init() {
+	RegisterFactory(K8sServiceBackendType, newK8sServiceBackend)
+}
 
 type K8sServiceBackend struct {
+	Config Config
+
+	client *pooledClient
+}
+
+// Invoke forwards req to target, with the path and query of req appended to
+// the target url.
+func (k *K8sServiceBackend) Invoke(target string, req *fasthttp.Request, res *fasthttp.Response,
+	timeout time.Duration, idleTimeout time.Duration) error {
+	uri := strings.TrimSuffix(target, "/") + string(req.URI().PathOriginal())
+	if query := req.URI().QueryString(); len(query) > 0 {
+		uri += "?" + string(query)
+	}
+	req.SetRequestURI(uri)
+	// fasthttp defaults the scheme of requests received over TLS to https,
+	// keep the one of target
+	if i := strings.Index(target, "://"); i > 0 {
+		req.URI().SetScheme(target[:i])
+	}
+	if timeout <= 0 {
+		timeout = k.Config.Timeout.Duration
+	}
+	return k.client.DoTimeout(req, res, timeout, idleTimeout)
 }
 
-func (k *K8sServiceBackend) Invoke(target string, req *fasthttp.Request, res *fasthttp.Response) error {
-	req.SetRequestURI(target)
-	return fasthttp.Do(req, res)
+// ValidateTarget checks that target is an http(s) url of a Service.
+func (k *K8sServiceBackend) ValidateTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("k8sservice target %q is not a valid url: %v", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("k8sservice target %q must use http or https", target)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("k8sservice target %q has no host", target)
+	}
+	return nil
 }
 
-func NewK8sServiceBackend() {
-	AddBackend(k8sServiceBackendName, &K8sServiceBackend{})
+// Probe sends GET path to the Service behind target and fails unless it
+// answers with a 2xx or 3xx status.
+func (k *K8sServiceBackend) Probe(target string, path string, timeout time.Duration) error {
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+
+	req.SetRequestURI(strings.TrimSuffix(target, "/") + "/" + strings.TrimPrefix(path, "/"))
+	req.Header.SetMethod(fasthttp.MethodGet)
+	if err := k.client.DoTimeout(req, res, timeout, 0); err != nil {
+		return err
+	}
+	if res.StatusCode() >= 400 {
+		return fmt.Errorf("probe returned status %d", res.StatusCode())
+	}
+	return nil
+}
+
+// PoolStats returns the open connections to each Service.
+func (k *K8sServiceBackend) PoolStats() PoolStats {
+	return k.client.stats()
+}
+
+func newK8sServiceBackend(config Config) (Backend, error) {
+	client, err := newPooledClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &K8sServiceBackend{
+		Config: config,
+		client: client,
+	}, nil
 }
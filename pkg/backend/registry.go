@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Factory creates a backend instance from its configuration.
+type Factory func(config Config) (Backend, error)
+
+var factories = map[string]Factory{}
+
+// DefaultTimeout bounds calls to backends configured without a timeout, so a
+// hung upstream can not hold a connection forever.
+const DefaultTimeout = 30 * time.Second
+
+// FileConfig is the content of the file passed to route-proxy -backendConfig.
+type FileConfig struct {
+	Backends []Config `json:"backends"`
+}
+
+// Config configures one backend instance. Routes select the instance by
+// Name in RouteTarget.Type, so two instances of the same Type can serve
+// different clusters.
+type Config struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Timeout bounds calls made without a timeout of their own. It defaults
+	// to DefaultTimeout.
+	// +optional
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// +optional
+	Endpoints []string `json:"endpoints,omitempty"`
+	// +optional
+	TLS TLSConfig `json:"tls,omitempty"`
+	// +optional
+	Pool PoolConfig `json:"pool,omitempty"`
+}
+
+// TLSConfig configures TLS to the upstreams of a backend. Their certificates
+// are verified unless InsecureSkipVerify is set, and the files are reloaded
+// when they change.
+type TLSConfig struct {
+	// InsecureSkipVerify disables verification of upstream certificates. It
+	// is meant for testing only and excludes CAFile.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// +optional
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are the client certificate presented for mTLS.
+	// +optional
+	CertFile string `json:"certFile,omitempty"`
+	// +optional
+	KeyFile string `json:"keyFile,omitempty"`
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// RegisterFactory makes a backend type available to configuration. Backend
+// types register themselves from init.
+func RegisterFactory(typ string, factory Factory) {
+	if _, ok := factories[typ]; ok {
+		panic(fmt.Sprintf("[backend] factory %s registered twice", typ))
+	}
+	factories[typ] = factory
+}
+
+// Types returns the registered backend types.
+func Types() []string {
+	types := make([]string, 0, len(factories))
+	for t := range factories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Names returns the names of the configured backend instances.
+func Names() []string {
+	names := make([]string, 0, len(Backends))
+	for n := range Backends {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackend creates a backend instance from config and adds it under
+// config.Name, which defaults to config.Type.
+func NewBackend(config Config) error {
+	if config.Name == "" {
+		config.Name = config.Type
+	}
+	if config.Timeout.Duration <= 0 {
+		config.Timeout.Duration = DefaultTimeout
+	}
+
+	factory, ok := factories[config.Type]
+	if !ok {
+		return fmt.Errorf("[backend] unknown backend type %s, registered: %v", config.Type, Types())
+	}
+	if _, ok := Backends[config.Name]; ok {
+		return fmt.Errorf("[backend] backend %s configured twice", config.Name)
+	}
+
+	bke, err := factory(config)
+	if err != nil {
+		return fmt.Errorf("[backend] create backend %s error: %v", config.Name, err)
+	}
+	AddBackend(config.Name, bke)
+
+	return nil
+}
+
+// LoadConfigFile reads a FileConfig from a YAML or JSON file.
+func LoadConfigFile(path string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &FileConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("[backend] parse %s error: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// Configure creates the backend instances listed in the config file at path.
+// Without a file it falls back to one k8sservice backend and, when
+// fnAccessor is set, one yuanrong backend using fnAccessorTLS, named after
+// their types.
+func Configure(path string, fnAccessor string, fnAccessorTLS TLSConfig) error {
+	var configs []Config
+	if path != "" {
+		fileConfig, err := LoadConfigFile(path)
+		if err != nil {
+			return err
+		}
+		configs = fileConfig.Backends
+	} else {
+		configs = append(configs, Config{Type: K8sServiceBackendType})
+		if fnAccessor != "" {
+			configs = append(configs, Config{
+				Type:      YuanrongBackendType,
+				Endpoints: []string{fnAccessor},
+				TLS:       fnAccessorTLS,
+			})
+		}
+	}
+
+	for _, config := range configs {
+		if err := NewBackend(config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
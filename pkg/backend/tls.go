@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// TLSReloadInterval is how often the TLS files of backends are checked for
+// changes.
+var TLSReloadInterval = 10 * time.Second
+
+// newTLSConfig builds the client TLS configuration of a backend. Upstream
+// certificates are verified against CAFile, or the system roots without
+// one, unless InsecureSkipVerify is set explicitly.
+func newTLSConfig(config TLSConfig) (*tls.Config, error) {
+	if config.InsecureSkipVerify && config.CAFile != "" {
+		return nil, fmt.Errorf("tls: insecureSkipVerify and caFile are exclusive")
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("tls: certFile and keyFile must be set together")
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		ServerName:         config.ServerName,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if config.CAFile != "" {
+		ca, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+	}
+
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// watchTLS polls the files of config every TLSReloadInterval and calls
+// reload with a new TLS configuration when any of them changed. A change
+// that does not load, like a certificate written before its key, is retried
+// on the next poll while the old configuration stays in use.
+func watchTLS(name string, config TLSConfig, reload func(*tls.Config)) {
+	var files []string
+	for _, file := range []string{config.CAFile, config.CertFile, config.KeyFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	loaded := fileVersions(files)
+	go func() {
+		for range time.Tick(TLSReloadInterval) {
+			current := fileVersions(files)
+			if current == loaded {
+				continue
+			}
+
+			tlsConfig, err := newTLSConfig(config)
+			if err != nil {
+				fmt.Printf("[backend] reload tls files of %s error: %v\n", name, err)
+				continue
+			}
+			reload(tlsConfig)
+			loaded = current
+			fmt.Printf("[backend] reloaded tls files of %s\n", name)
+		}
+	}()
+}
+
+// fileVersions identifies the content of files by their size and
+// modification time. Files are followed through symlinks, as Secret volumes
+// swap them on update.
+func fileVersions(files []string) string {
+	version := ""
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			version += file + ":missing;"
+			continue
+		}
+		version += fmt.Sprintf("%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return version
+}
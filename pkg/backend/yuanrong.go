@@ -1,36 +1,77 @@
 package backend
 
 import (
-	"crypto/tls"
 	"fmt"
+	"regexp"
+	"sync/atomic"
+	"time"
+
 	"github.com/valyala/fasthttp"
 )
 
-const yuanrongBackendName = "yuanrong"
+const YuanrongBackendType = "yuanrong"
+
+// functionURN matches Yuanrong function URNs, laid out as
+// sn:cn:yrk:<tenant>:function:<name>:<version> like
+// "sn:cn:yrk:12345678:function:0-default-hello:$latest".
+var functionURN = regexp.MustCompile(
+	`^sn:cn:yrk:[A-Za-z0-9]+:function:[A-Za-z0-9][A-Za-z0-9_-]*:(\$latest|[A-Za-z0-9][A-Za-z0-9_.-]*)$`)
+
+func init() {
+	RegisterFactory(YuanrongBackendType, newYuanrongBackend)
+}
 
 type YuanrongBackend struct {
-	Server string
+	Config Config
+
+	client *pooledClient
+	next   uint32
 }
 
-func (y *YuanrongBackend) Invoke(target string, req *fasthttp.Request, res *fasthttp.Response) error {
+func (y *YuanrongBackend) Invoke(target string, req *fasthttp.Request, res *fasthttp.Response,
+	timeout time.Duration, idleTimeout time.Duration) error {
 	uri := fmt.Sprintf("https://%s/serverless/v1/functions/%s/invocations",
-		y.Server, target)
-	fmt.Printf("yuanrong uri %s\n", uri)
+		y.server(), target)
 
 	req.SetRequestURI(uri)
 
-	insecureClient := fasthttp.Client{
-		TLSConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+	if timeout <= 0 {
+		timeout = y.Config.Timeout.Duration
+	}
+	return y.client.DoTimeout(req, res, timeout, idleTimeout)
+}
+
+// ValidateTarget checks that target is a function urn.
+func (y *YuanrongBackend) ValidateTarget(target string) error {
+	if !functionURN.MatchString(target) {
+		return fmt.Errorf("yuanrong target %q is not a function urn", target)
 	}
+	return nil
+}
 
-	return insecureClient.Do(req, res)
+// server picks the FnAccessor endpoints round robin.
+func (y *YuanrongBackend) server() string {
+	n := atomic.AddUint32(&y.next, 1)
+	return y.Config.Endpoints[int(n)%len(y.Config.Endpoints)]
 }
 
-func NewYuanrongBackend(server string) {
-	yBackend := &YuanrongBackend{
-		Server: server,
+func newYuanrongBackend(config Config) (Backend, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("yuanrong backend needs at least one FnAccessor endpoint")
+	}
+
+	client, err := newPooledClient(config)
+	if err != nil {
+		return nil, err
 	}
-	AddBackend(yuanrongBackendName, yBackend)
+
+	return &YuanrongBackend{
+		Config: config,
+		client: client,
+	}, nil
+}
+
+// PoolStats returns the open connections to each FnAccessor.
+func (y *YuanrongBackend) PoolStats() PoolStats {
+	return y.client.stats()
 }
@@ -0,0 +1,433 @@
+package breaker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+const (
+	DefaultPendingTimeout   = time.Second
+	DefaultMinRequests      = 10
+	DefaultWindow           = 10 * time.Second
+	DefaultOpenTime         = 30 * time.Second
+	DefaultHalfOpenRequests = 1
+)
+
+// Breaker states.
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+var (
+	// ErrOpen is returned by Acquire while the breaker is open.
+	ErrOpen = errors.New("circuit open")
+	// ErrOverflow is returned by Acquire when no request slot freed up in
+	// time or too many requests are waiting for one.
+	ErrOverflow = errors.New("too many concurrent requests")
+)
+
+// Config is a v1alpha1.CircuitBreaker with defaults applied.
+type Config struct {
+	MaxRequests      int
+	MaxPending       int
+	PendingTimeout   time.Duration
+	ErrorRate        int
+	SlowRequest      time.Duration
+	MinRequests      int
+	Window           time.Duration
+	OpenTime         time.Duration
+	HalfOpenRequests int
+}
+
+// NewConfig applies the defaults to cb.
+func NewConfig(cb *v1alpha1.CircuitBreaker) Config {
+	c := Config{
+		MaxRequests:      int(cb.MaxRequests),
+		MaxPending:       int(cb.MaxPending),
+		PendingTimeout:   DefaultPendingTimeout,
+		ErrorRate:        int(cb.ErrorRate),
+		MinRequests:      DefaultMinRequests,
+		Window:           DefaultWindow,
+		OpenTime:         DefaultOpenTime,
+		HalfOpenRequests: DefaultHalfOpenRequests,
+	}
+	if cb.PendingTimeout != nil {
+		c.PendingTimeout = cb.PendingTimeout.Duration
+	}
+	if cb.SlowRequest != nil {
+		c.SlowRequest = cb.SlowRequest.Duration
+	}
+	if cb.MinRequests > 0 {
+		c.MinRequests = int(cb.MinRequests)
+	}
+	if cb.Window != nil && cb.Window.Duration > 0 {
+		c.Window = cb.Window.Duration
+	}
+	if cb.OpenTime != nil && cb.OpenTime.Duration > 0 {
+		c.OpenTime = cb.OpenTime.Duration
+	}
+	if cb.HalfOpenRequests > 0 {
+		c.HalfOpenRequests = int(cb.HalfOpenRequests)
+	}
+	return c
+}
+
+// Breaker limits the concurrent requests to one target and short-circuits
+// it while it fails. A nil Breaker lets everything through.
+//
+// It is closed until ErrorRate percent of at least MinRequests requests in a
+// Window fail, then open for OpenTime, then half-open: HalfOpenRequests are
+// let through, it closes when they all succeed and opens again otherwise.
+type Breaker struct {
+	uri       string
+	matchType string
+	target    v1alpha1.RouteTarget
+	config    Config
+	slots     chan struct{}
+
+	mu         sync.Mutex
+	state      string
+	generation uint64
+	pending    int
+	// requests and failures in the window started at windowStart
+	windowStart time.Time
+	requests    int
+	failures    int
+	// half-open requests admitted and succeeded
+	admitted  int
+	succeeded int
+	openUntil time.Time
+
+	opens    uint64
+	rejected uint64
+}
+
+// Status is the state of a Breaker.
+type Status struct {
+	URI       string     `json:"uri"`
+	MatchType string     `json:"matchType"`
+	Type      string     `json:"type"`
+	Target    string     `json:"target"`
+	State     string     `json:"state"`
+	InFlight  int        `json:"inFlight"`
+	Pending   int        `json:"pending"`
+	Requests  int        `json:"requests"`
+	Failures  int        `json:"failures"`
+	OpenUntil *time.Time `json:"openUntil,omitempty"`
+	// Opens counts the times the breaker opened.
+	Opens uint64 `json:"opens"`
+	// Rejected counts requests rejected because the breaker was open or the
+	// target had no slot for them.
+	Rejected uint64 `json:"rejected"`
+}
+
+func newBreaker(uri string, target v1alpha1.RouteTarget, config Config) *Breaker {
+	b := &Breaker{uri: uri, target: target, config: config, state: StateClosed}
+	if config.MaxRequests > 0 {
+		b.slots = make(chan struct{}, config.MaxRequests)
+	}
+	return b
+}
+
+// Peek returns the state of the breaker and whether Acquire may let a
+// request through now, so targets with an open breaker are not picked. It
+// leaves the state alone, an open breaker whose OpenTime passed is reported
+// half-open but only moves there on Acquire, so debug endpoints can poll it.
+func (b *Breaker) Peek() (state string, available bool) {
+	if b == nil {
+		return StateClosed, true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.peek(time.Now())
+}
+
+// Acquire waits up to PendingTimeout, and not past deadline unless it is
+// zero, for a request slot and admits a request to the target. done must be
+// called with the outcome of the request once it completed.
+func (b *Breaker) Acquire(deadline time.Time) (done func(success bool), err error) {
+	if b == nil {
+		return func(bool) {}, nil
+	}
+
+	if err := b.acquireSlot(deadline); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	if !b.admits(now) {
+		b.rejected++
+		b.mu.Unlock()
+		b.releaseSlot()
+		return nil, ErrOpen
+	}
+	if b.state == StateHalfOpen {
+		b.admitted++
+	}
+	generation := b.generation
+	b.mu.Unlock()
+
+	return func(success bool) {
+		b.releaseSlot()
+		if b.config.SlowRequest > 0 && time.Since(now) > b.config.SlowRequest {
+			success = false
+		}
+		b.record(generation, success, time.Now())
+	}, nil
+}
+
+// Status returns the state of the breaker. Like Peek, it leaves an open
+// breaker alone once OpenTime passed and reports it half-open.
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	state, _ := b.peek(now)
+	status := Status{
+		URI:       b.uri,
+		MatchType: b.matchType,
+		Type:      b.target.Type,
+		Target:    b.target.Target,
+		State:     state,
+		InFlight:  len(b.slots),
+		Pending:   b.pending,
+		Opens:     b.opens,
+		Rejected:  b.rejected,
+	}
+	if state == b.state && now.Before(b.windowStart.Add(b.config.Window)) {
+		status.Requests, status.Failures = b.requests, b.failures
+	}
+	if state == StateOpen {
+		until := b.openUntil
+		status.OpenUntil = &until
+	}
+	return status
+}
+
+// acquireSlot takes one of MaxRequests slots, waiting for one when fewer
+// than MaxPending requests already are.
+func (b *Breaker) acquireSlot(deadline time.Time) error {
+	if b.slots == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	wait := b.config.PendingTimeout
+	if left := time.Until(deadline); !deadline.IsZero() && left < wait {
+		wait = left
+	}
+	b.mu.Lock()
+	if b.pending >= b.config.MaxPending || wait <= 0 {
+		b.rejected++
+		b.mu.Unlock()
+		return ErrOverflow
+	}
+	b.pending++
+	b.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case b.slots <- struct{}{}:
+	case <-timer.C:
+		err = ErrOverflow
+	}
+
+	b.mu.Lock()
+	b.pending--
+	if err != nil {
+		b.rejected++
+	}
+	b.mu.Unlock()
+	return err
+}
+
+func (b *Breaker) releaseSlot() {
+	if b.slots != nil {
+		<-b.slots
+	}
+}
+
+// admits reports whether a request may go through at now, moving an open
+// breaker to half-open once OpenTime passed. Must be called with mu held.
+func (b *Breaker) admits(now time.Time) bool {
+	state, available := b.peek(now)
+	if state != b.state {
+		b.setState(state, now)
+	}
+	return available
+}
+
+// peek returns the state of the breaker at now, an open one being half-open
+// once OpenTime passed, and whether it admits a request. Must be called
+// with mu held.
+func (b *Breaker) peek(now time.Time) (string, bool) {
+	switch b.state {
+	case StateOpen:
+		if now.Before(b.openUntil) {
+			return StateOpen, false
+		}
+		// no request was admitted since it opened
+		return StateHalfOpen, true
+	case StateHalfOpen:
+		return StateHalfOpen, b.admitted < b.config.HalfOpenRequests
+	}
+	return b.state, true
+}
+
+// record counts the outcome of a request admitted in generation. Outcomes
+// of requests admitted before the last state change are ignored.
+func (b *Breaker) record(generation uint64, success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation || b.config.ErrorRate <= 0 {
+		return
+	}
+
+	switch b.state {
+	case StateHalfOpen:
+		if !success {
+			b.setState(StateOpen, now)
+			return
+		}
+		if b.succeeded++; b.succeeded >= b.config.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	case StateClosed:
+		if !now.Before(b.windowStart.Add(b.config.Window)) {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= b.config.MinRequests && b.failures*100 >= b.config.ErrorRate*b.requests {
+			b.setState(StateOpen, now)
+		}
+	}
+}
+
+// setState moves the breaker to state. Must be called with mu held.
+func (b *Breaker) setState(state string, now time.Time) {
+	b.state = state
+	b.generation++
+	b.admitted, b.succeeded = 0, 0
+	b.windowStart, b.requests, b.failures = now, 0, 0
+	if state == StateOpen {
+		b.opens++
+		b.openUntil = now.Add(b.config.OpenTime)
+	}
+}
+
+// Set holds the breakers of the targets of one rule. A nil Set has nil
+// breakers.
+type Set struct {
+	uri       string
+	matchType string
+	config    Config
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewSet returns the breakers of the targets of the rule at uri of
+// matchType.
+func NewSet(uri string, matchType string, config Config) *Set {
+	return &Set{uri: uri, matchType: matchType, config: config, breakers: map[string]*Breaker{}}
+}
+
+// Config returns the config of the breakers.
+func (s *Set) Config() Config {
+	return s.config
+}
+
+// Get returns the breaker of target.
+func (s *Set) Get(target v1alpha1.RouteTarget) *Breaker {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := targetID(target)
+	b, ok := s.breakers[id]
+	if !ok {
+		b = newBreaker(s.uri, target, s.config)
+		b.matchType = s.matchType
+		s.breakers[id] = b
+	}
+	return b
+}
+
+// Lookup returns the breaker of target, nil when none was created yet.
+func (s *Set) Lookup(target v1alpha1.RouteTarget) *Breaker {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.breakers[targetID(target)]
+}
+
+// Retain drops the breakers of targets no longer in targets.
+func (s *Set) Retain(targets []v1alpha1.RouteTarget) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keep := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		keep[targetID(t)] = true
+	}
+	for id := range s.breakers {
+		if !keep[id] {
+			delete(s.breakers, id)
+		}
+	}
+}
+
+// Status returns the state of the breakers used so far.
+func (s *Set) Status() []Status {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	breakers := make([]*Breaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		breakers = append(breakers, b)
+	}
+	s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(breakers))
+	for _, b := range breakers {
+		statuses = append(statuses, b.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Target < statuses[j].Target
+	})
+	return statuses
+}
+
+func targetID(t v1alpha1.RouteTarget) string {
+	return t.Type + "/" + t.Target
+}
@@ -0,0 +1,162 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+// TestStates checks that a breaker opens on failures, half-opens after
+// OpenTime, and closes or opens again on the outcome of its trial request.
+func TestStates(t *testing.T) {
+	const openTime = 50 * time.Millisecond
+	b := newBreaker("a.com/fn", v1alpha1.RouteTarget{Target: "t"}, Config{
+		ErrorRate:        50,
+		MinRequests:      2,
+		Window:           time.Minute,
+		OpenTime:         openTime,
+		HalfOpenRequests: 1,
+	})
+
+	tests := []struct {
+		name    string
+		wait    time.Duration
+		err     error
+		success bool
+		state   string
+	}{
+		{name: "success", success: true, state: StateClosed},
+		{name: "failure rate reached", state: StateOpen},
+		{name: "open", err: ErrOpen, state: StateOpen},
+		{name: "trial fails", wait: openTime, state: StateOpen},
+		{name: "open again", err: ErrOpen, state: StateOpen},
+		{name: "trial succeeds", wait: openTime, success: true, state: StateClosed},
+		{name: "closed", state: StateClosed},
+	}
+	for _, tt := range tests {
+		time.Sleep(tt.wait)
+		done, err := b.Acquire(time.Time{})
+		if err != tt.err {
+			t.Fatalf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+		if err == nil {
+			done(tt.success)
+		}
+		if state := b.Status().State; state != tt.state {
+			t.Fatalf("%s: got state %s, want %s", tt.name, state, tt.state)
+		}
+	}
+	if opens := b.Status().Opens; opens != 2 {
+		t.Errorf("opened %d times, want 2", opens)
+	}
+}
+
+// TestHalfOpenRequests checks that a half-open breaker lets only
+// HalfOpenRequests through until they complete.
+func TestHalfOpenRequests(t *testing.T) {
+	b := newBreaker("a.com/fn", v1alpha1.RouteTarget{Target: "t"}, Config{
+		ErrorRate:        50,
+		MinRequests:      1,
+		Window:           time.Minute,
+		HalfOpenRequests: 2,
+	})
+	done, err := b.Acquire(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(false)
+
+	var trials []func(bool)
+	for i := 0; i < 2; i++ {
+		done, err := b.Acquire(time.Time{})
+		if err != nil {
+			t.Fatalf("trial %d: %v", i, err)
+		}
+		trials = append(trials, done)
+	}
+	if _, err := b.Acquire(time.Time{}); err != ErrOpen {
+		t.Errorf("third request while half-open: got %v, want %v", err, ErrOpen)
+	}
+
+	trials[0](true)
+	if state := b.Status().State; state != StateHalfOpen {
+		t.Errorf("after one trial succeeded: got state %s, want %s", state, StateHalfOpen)
+	}
+	trials[1](true)
+	if state := b.Status().State; state != StateClosed {
+		t.Errorf("after both trials succeeded: got state %s, want %s", state, StateClosed)
+	}
+}
+
+// TestMaxRequests checks that requests over MaxRequests wait for a slot, up
+// to MaxPending of them and until PendingTimeout or their deadline.
+func TestMaxRequests(t *testing.T) {
+	b := newBreaker("a.com/fn", v1alpha1.RouteTarget{Target: "t"}, Config{
+		MaxRequests:    1,
+		MaxPending:     1,
+		PendingTimeout: 5 * time.Second,
+	})
+	done, err := b.Acquire(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Acquire(time.Now().Add(20 * time.Millisecond)); err != ErrOverflow {
+		t.Errorf("request waiting past its deadline: got %v, want %v", err, ErrOverflow)
+	}
+
+	waited := make(chan error)
+	go func() {
+		done, err := b.Acquire(time.Time{})
+		if err == nil {
+			done(true)
+		}
+		waited <- err
+	}()
+	for b.Status().Pending == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := b.Acquire(time.Time{}); err != ErrOverflow {
+		t.Errorf("request over MaxPending: got %v, want %v", err, ErrOverflow)
+	}
+	done(true)
+	if err := <-waited; err != nil {
+		t.Errorf("pending request got %v once a slot freed up", err)
+	}
+}
+
+// TestPeek checks that Peek and Status report an open breaker past its
+// OpenTime as half-open without moving it there.
+func TestPeek(t *testing.T) {
+	const openTime = 20 * time.Millisecond
+	b := newBreaker("a.com/fn", v1alpha1.RouteTarget{Target: "t"}, Config{
+		ErrorRate:        50,
+		MinRequests:      1,
+		Window:           time.Minute,
+		OpenTime:         openTime,
+		HalfOpenRequests: 1,
+	})
+	done, err := b.Acquire(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(false)
+
+	if state, available := b.Peek(); state != StateOpen || available {
+		t.Errorf("got %s available %v, want open and unavailable", state, available)
+	}
+	time.Sleep(openTime)
+	generation := b.generation
+	for i := 0; i < 3; i++ {
+		if state, available := b.Peek(); state != StateHalfOpen || !available {
+			t.Errorf("got %s available %v, want half-open and available", state, available)
+		}
+		if state := b.Status().State; state != StateHalfOpen {
+			t.Errorf("got status %s, want half-open", state)
+		}
+	}
+	if b.state != StateOpen || b.generation != generation {
+		t.Errorf("peeking moved the breaker to %s", b.state)
+	}
+}
@@ -0,0 +1,230 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store holds serving certificates by source, like a Secret or a file, and
+// selects one by the SNI name of a TLS handshake.
+type Store struct {
+	mu      sync.RWMutex
+	sources map[string][]*tls.Certificate
+	// hosts limits the names the certificates of a source are served for,
+	// all of their names when a source has none
+	hosts map[string][]string
+	// names indexes certificates by the lowercase DNS names they are valid
+	// for, wildcards included as "*.example.com"
+	names map[string]*tls.Certificate
+}
+
+func NewStore() *Store {
+	return &Store{
+		sources: map[string][]*tls.Certificate{},
+		hosts:   map[string][]string{},
+		names:   map[string]*tls.Certificate{},
+	}
+}
+
+// Set replaces the certificates of source, served for all of their names.
+func (s *Store) Set(source string, certs ...tls.Certificate) error {
+	return s.SetForHosts(source, nil, certs...)
+}
+
+// SetForHosts replaces the certificates of source, served only for the
+// names of hosts they are valid for, so a certificate can not take over the
+// names of others. A host may be a "*." wildcard. It fails when certs are
+// valid for none of hosts.
+func (s *Store) SetForHosts(source string, hosts []string, certs ...tls.Certificate) error {
+	loaded, err := parse(source, certs)
+	if err != nil {
+		return err
+	}
+	if hosts != nil {
+		served := false
+		for _, cert := range loaded {
+			served = served || len(servedNames(cert, hosts)) > 0
+		}
+		if !served {
+			return fmt.Errorf("[certs] %s: certificate is not valid for %s", source, strings.Join(hosts, ", "))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sources[source] = loaded
+	if hosts != nil {
+		s.hosts[source] = hosts
+	} else {
+		delete(s.hosts, source)
+	}
+	s.index()
+	return nil
+}
+
+// ReplacePrefix replaces the certificates of every source starting with
+// prefix by certs, keyed by source, at once.
+func (s *Store) ReplacePrefix(prefix string, certs map[string][]tls.Certificate) error {
+	sources := map[string][]*tls.Certificate{}
+	for source, c := range certs {
+		if !strings.HasPrefix(source, prefix) {
+			return fmt.Errorf("[certs] source %s does not start with %s", source, prefix)
+		}
+		loaded, err := parse(source, c)
+		if err != nil {
+			return err
+		}
+		sources[source] = loaded
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for source := range s.sources {
+		if strings.HasPrefix(source, prefix) {
+			delete(s.sources, source)
+			delete(s.hosts, source)
+		}
+	}
+	for source, loaded := range sources {
+		s.sources[source] = loaded
+	}
+	s.index()
+	return nil
+}
+
+// Remove drops the certificates of source.
+func (s *Store) Remove(source string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sources[source]; !ok {
+		return
+	}
+	delete(s.sources, source)
+	delete(s.hosts, source)
+	s.index()
+}
+
+// Len returns the number of certificates held.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, certs := range s.sources {
+		n += len(certs)
+	}
+	return n
+}
+
+// GetCertificate returns the certificate for the SNI name of hello: one
+// valid for the exact name, else one for its wildcard. It is meant for
+// tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cert, ok := s.names[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.names["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("[certs] no certificate for server name %q", hello.ServerName)
+}
+
+// parse fills in the leaf of certs, which names are indexed by.
+func parse(source string, certs []tls.Certificate) ([]*tls.Certificate, error) {
+	loaded := make([]*tls.Certificate, 0, len(certs))
+	for i := range certs {
+		cert := certs[i]
+		if cert.Leaf == nil {
+			if len(cert.Certificate) == 0 {
+				return nil, fmt.Errorf("[certs] %s: empty certificate chain", source)
+			}
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return nil, fmt.Errorf("[certs] %s: %v", source, err)
+			}
+			cert.Leaf = leaf
+		}
+		loaded = append(loaded, &cert)
+	}
+	return loaded, nil
+}
+
+// index rebuilds names. Sources are walked in order so a name claimed by
+// two sources always gets the certificate of the first one.
+// Must be called with mu held.
+func (s *Store) index() {
+	sources := make([]string, 0, len(s.sources))
+	for source := range s.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	s.names = map[string]*tls.Certificate{}
+	for _, source := range sources {
+		for _, cert := range s.sources[source] {
+			for _, name := range servedNames(cert, s.hosts[source]) {
+				if _, ok := s.names[name]; !ok {
+					s.names[name] = cert
+				}
+			}
+		}
+	}
+}
+
+// servedNames returns the lowercase names cert is served for: its DNS names,
+// or only those of hosts it is valid for when hosts is not nil. A wildcard
+// certificate is served for an exact host under it, but a wildcard host only
+// for the names of cert under it.
+func servedNames(cert *tls.Certificate, hosts []string) []string {
+	names := cert.Leaf.DNSNames
+	if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+		names = []string{cert.Leaf.Subject.CommonName}
+	}
+
+	var served []string
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if hosts == nil {
+			served = append(served, name)
+			continue
+		}
+		for _, host := range hosts {
+			host = strings.ToLower(host)
+			switch {
+			case name == host:
+				served = append(served, name)
+			case strings.HasPrefix(host, "*."):
+				if underWildcard(host, name) {
+					served = append(served, name)
+				}
+			case underWildcard(name, host):
+				served = append(served, host)
+			}
+		}
+	}
+	return served
+}
+
+// underWildcard reports whether the wildcard name "*.example.com" covers
+// name, which must have exactly one more label.
+func underWildcard(wildcard, name string) bool {
+	if !strings.HasPrefix(wildcard, "*.") || strings.HasPrefix(name, "*.") {
+		return false
+	}
+	i := strings.IndexByte(name, '.')
+	return i > 0 && name[i:] == wildcard[1:]
+}
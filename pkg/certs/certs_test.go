@@ -0,0 +1,78 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newCert returns a self-signed certificate valid for names.
+func newCert(t *testing.T, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestSetForHosts checks that the certificate of a source limited to hosts
+// does not take over the other names it is valid for.
+func TestSetForHosts(t *testing.T) {
+	s := NewStore()
+	victim := newCert(t, "victim.com")
+	if err := s.SetForHosts("secret:b/victim", []string{"victim.com"}, victim); err != nil {
+		t.Fatal(err)
+	}
+	// sorted first, valid for victim.com too
+	attacker := newCert(t, "attacker.com", "victim.com", "*.shop.com")
+	if err := s.SetForHosts("secret:a/attacker", []string{"attacker.com"}, attacker); err != nil {
+		t.Fatal(err)
+	}
+	wildcard := newCert(t, "*.api.com")
+	if err := s.SetForHosts("secret:c/wildcard", []string{"eu.api.com", "*.apps.com"}, wildcard); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetForHosts("secret:d/none", []string{"other.com"}, newCert(t, "victim.com")); err == nil {
+		t.Errorf("certificate set for a host it is not valid for")
+	}
+
+	tests := []struct {
+		name string
+		want *tls.Certificate
+	}{
+		{"victim.com", &victim},
+		{"attacker.com", &attacker},
+		{"a.shop.com", nil},
+		{"eu.api.com", &wildcard},
+		{"us.api.com", nil},
+		{"x.apps.com", nil},
+	}
+	for _, tt := range tests {
+		got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.name})
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("%s: got certificate for %v", tt.name, got.Leaf.DNSNames)
+		case tt.want != nil && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != nil && string(got.Certificate[0]) != string(tt.want.Certificate[0]):
+			t.Errorf("%s: got certificate for %v", tt.name, got.Leaf.DNSNames)
+		}
+	}
+}
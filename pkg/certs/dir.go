@@ -0,0 +1,93 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dirSourcePrefix prefixes the sources of certificates loaded from files.
+const dirSourcePrefix = "file:"
+
+// LoadDir replaces the certificates loaded from files with the pairs found in
+// dir: each <name>.crt with its <name>.key.
+func (s *Store) LoadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	certs := map[string][]tls.Certificate{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".crt") {
+			continue
+		}
+		certFile := filepath.Join(dir, file.Name())
+		keyFile := strings.TrimSuffix(certFile, ".crt") + ".key"
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("[certs] load %s error: %v", certFile, err)
+		}
+		certs[dirSourcePrefix+certFile] = []tls.Certificate{cert}
+	}
+
+	return s.ReplacePrefix(dirSourcePrefix, certs)
+}
+
+// WatchDir loads dir and reloads it every interval when its files changed,
+// until stopCh is closed. A reload that fails keeps the certificates loaded
+// before and is retried on the next change.
+func (s *Store) WatchDir(dir string, interval time.Duration, stopCh <-chan struct{}) error {
+	if err := s.LoadDir(dir); err != nil {
+		return err
+	}
+
+	loaded := dirVersion(dir)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+
+			current := dirVersion(dir)
+			if current == loaded {
+				continue
+			}
+			if err := s.LoadDir(dir); err != nil {
+				fmt.Printf("[certs] reload %s error: %v\n", dir, err)
+				continue
+			}
+			loaded = current
+			fmt.Printf("[certs] reloaded %s, %d certificates\n", dir, s.Len())
+		}
+	}()
+	return nil
+}
+
+// dirVersion identifies the content of dir by the names, sizes and
+// modification times of its files, following symlinks.
+func dirVersion(dir string) string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	version := ""
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		version += fmt.Sprintf("%s:%d:%d;", file.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return version
+}
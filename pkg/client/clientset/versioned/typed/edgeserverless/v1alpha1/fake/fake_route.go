@@ -102,6 +102,18 @@ func (c *FakeRoutes) Update(ctx context.Context, route *v1alpha1.Route, opts v1.
 	return obj.(*v1alpha1.Route), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeRoutes) UpdateStatus(ctx context.Context, route *v1alpha1.Route, opts v1.UpdateOptions) (*v1alpha1.Route, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(routesResource, "status", c.ns, route), &v1alpha1.Route{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.Route), err
+}
+
 // Delete takes name of the route and deletes it. Returns an error if one occurs.
 func (c *FakeRoutes) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	_, err := c.Fake.
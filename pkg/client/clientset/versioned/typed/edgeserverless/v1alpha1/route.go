@@ -40,6 +40,7 @@ type RoutesGetter interface {
 type RouteInterface interface {
 	Create(ctx context.Context, route *v1alpha1.Route, opts v1.CreateOptions) (*v1alpha1.Route, error)
 	Update(ctx context.Context, route *v1alpha1.Route, opts v1.UpdateOptions) (*v1alpha1.Route, error)
+	UpdateStatus(ctx context.Context, route *v1alpha1.Route, opts v1.UpdateOptions) (*v1alpha1.Route, error)
 	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
 	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
 	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1alpha1.Route, error)
@@ -135,6 +136,22 @@ func (c *routes) Update(ctx context.Context, route *v1alpha1.Route, opts v1.Upda
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *routes) UpdateStatus(ctx context.Context, route *v1alpha1.Route, opts v1.UpdateOptions) (result *v1alpha1.Route, err error) {
+	result = &v1alpha1.Route{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("routes").
+		Name(route.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(route).
+		Do(ctx).
+		Into(result)
+	return
+}
+
 // Delete takes name of the route and deletes it. Returns an error if one occurs.
 func (c *routes) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	edgeserverless "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+)
+
+// certSource returns the source of the certificate of the route with key in
+// the certificate store.
+func certSource(key string) string {
+	return "secret:" + key
+}
+
+// syncCertificate serves the certificate of the Secret referenced by route
+// while the route owns its uri, for the host of the route only. A Secret
+// that does not parse keeps the certificate loaded before, if any, in use.
+func (c *RouteController) syncCertificate(key string, route *edgeserverless.Route, owned bool) error {
+	if c.certs == nil {
+		return nil
+	}
+	if !owned || route.Spec.TLS == nil {
+		c.certs.Remove(certSource(key))
+		return nil
+	}
+
+	host, _, matchType := rulesmanager.NormalizeURI(route.Spec.URI, route.Spec.MatchType)
+	if matchType == edgeserverless.MatchRegex {
+		c.certs.Remove(certSource(key))
+		return fmt.Errorf("tls is not served for regex uris")
+	}
+
+	name := route.Spec.TLS.SecretName
+	secret, err := c.secretsLister.Secrets(route.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		c.certs.Remove(certSource(key))
+		return fmt.Errorf("secret %s/%s not found", route.Namespace, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("secret %s/%s: %v", route.Namespace, name, err)
+	}
+	return c.certs.SetForHosts(certSource(key), []string{host}, cert)
+}
+
+// enqueueSecretRoutes enqueues the routes referencing the Secret obj, so
+// they pick up its changes.
+func (c *RouteController) enqueueSecretRoutes(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	routes, err := c.routesLister.Routes(namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, r := range routes {
+		if r.Spec.TLS != nil && r.Spec.TLS.SecretName == name {
+			c.workQueue.Add(routeKey(r))
+		}
+	}
+}
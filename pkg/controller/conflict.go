@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	edgeserverless "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+	"github.com/seveirbian/edgeserverless/pkg/validation"
+)
+
+// claim is the uri a route owns in the rules table.
+type claim struct {
+	// uri and matchType are those of the rule, uriKey its
+	// rulesmanager.URIKey, under which it is loaded and its targets' health
+	// is tracked
+	uri       string
+	matchType string
+	uriKey    string
+}
+
+func routeClaim(route *edgeserverless.Route) claim {
+	return claim{
+		uri:       route.Spec.URI,
+		matchType: route.Spec.MatchType,
+		uriKey:    rulesmanager.URIKey(route.Spec.URI, route.Spec.MatchType),
+	}
+}
+
+// uriWinner returns the key of the route that owns uriKey: the oldest valid
+// route claiming it, with the key breaking ties.
+func (c *RouteController) uriWinner(uriKey string) (string, error) {
+	claimants, err := c.claimants(uriKey)
+	if err != nil {
+		return "", err
+	}
+	if len(claimants) == 0 {
+		return "", nil
+	}
+
+	sort.Slice(claimants, func(i, j int) bool {
+		ti, tj := claimants[i].CreationTimestamp, claimants[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return routeKey(claimants[i]) < routeKey(claimants[j])
+	})
+
+	return routeKey(claimants[0]), nil
+}
+
+// claimants returns the live, valid routes whose uri has the key uriKey.
+func (c *RouteController) claimants(uriKey string) ([]*edgeserverless.Route, error) {
+	routes, err := c.routesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var claimants []*edgeserverless.Route
+	for _, r := range routes {
+		if r.DeletionTimestamp != nil || routeClaim(r).uriKey != uriKey {
+			continue
+		}
+		if validation.ValidateRouteSpec(&r.Spec) != nil {
+			continue
+		}
+		claimants = append(claimants, r)
+	}
+	return claimants, nil
+}
+
+// claimURI loads route into the rules table as the owner of its uri. A
+// previous owner is requeued so it can report itself as conflicted, its rule
+// is replaced as it has the same key. Must be called with ownerLock held.
+func (c *RouteController) claimURI(key string, route *edgeserverless.Route) error {
+	claimed := routeClaim(route)
+	if value, ok := c.uriToRoute.Load(claimed.uriKey); ok {
+		if owner, ok := value.(string); ok && owner != key {
+			c.uriToRoute.Delete(claimed.uriKey)
+			c.routeToURI.Delete(owner)
+			c.workQueue.Add(owner)
+		}
+	}
+
+	source := rulesmanager.Source{
+		Namespace:       route.Namespace,
+		Name:            route.Name,
+		ResourceVersion: route.ResourceVersion,
+	}
+	if err := c.rulesManager.AddRule(claimed.uri, route.Spec, source); err != nil {
+		return err
+	}
+	c.uriToRoute.Store(claimed.uriKey, key)
+	c.routeToURI.Store(key, claimed)
+	c.health.Track(claimed.uriKey, key, &route.Spec)
+
+	return nil
+}
+
+// releaseURI drops the rule owned by key, if any, and requeues the other
+// routes claiming the same uri so the next one in line can take it over.
+// Must be called with ownerLock held.
+func (c *RouteController) releaseURI(key string) {
+	value, ok := c.routeToURI.Load(key)
+	if !ok {
+		return
+	}
+	c.routeToURI.Delete(key)
+
+	claimed, ok := value.(claim)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("[controller] not a valid uri claim %v", value))
+		return
+	}
+
+	if owner, ok := c.uriToRoute.Load(claimed.uriKey); ok && owner == key {
+		c.rulesManager.DeleteRule(claimed.uri, claimed.matchType)
+		c.uriToRoute.Delete(claimed.uriKey)
+		c.health.Untrack(claimed.uriKey)
+	}
+
+	claimants, err := c.claimants(claimed.uriKey)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, r := range claimants {
+		if k := routeKey(r); k != key {
+			c.workQueue.Add(k)
+		}
+	}
+}
+
+func routeKey(route *edgeserverless.Route) string {
+	key, _ := cache.MetaNamespaceKeyFunc(route)
+	return key
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	edgeserverless "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/client/clientset/versioned/fake"
+	listers "github.com/seveirbian/edgeserverless/pkg/client/listers/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/health"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+)
+
+// testController is a RouteController syncing the routes of its indexer
+// with a fake clientset.
+type testController struct {
+	*RouteController
+	indexer cache.Indexer
+}
+
+func newTestController() *testController {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	return &testController{
+		RouteController: &RouteController{
+			routeClientSet: fake.NewSimpleClientset(),
+			routesLister:   listers.NewRouteLister(indexer),
+			workQueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Routes"),
+			recorder:       record.NewFakeRecorder(100),
+			rulesManager:   rulesmanager.NewRulesManager(),
+			health:         health.NewManager(),
+			proxyName:      "proxy",
+		},
+		indexer: indexer,
+	}
+}
+
+// apply stores route, or deletes it when delete is set, and syncs it.
+func (c *testController) apply(t *testing.T, route *edgeserverless.Route, delete bool) {
+	var err error
+	switch {
+	case delete:
+		err = c.indexer.Delete(route)
+	default:
+		if _, getErr := c.routeClientSet.EdgeserverlessV1alpha1().Routes(route.Namespace).
+			Get(context.TODO(), route.Name, metav1.GetOptions{}); getErr != nil {
+			_, err = c.routeClientSet.EdgeserverlessV1alpha1().Routes(route.Namespace).
+				Create(context.TODO(), route, metav1.CreateOptions{})
+		}
+		if err == nil {
+			err = c.indexer.Update(route)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.syncHandler(routeKey(route)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newRoute(name string, uri string, matchType string, target string) *edgeserverless.Route {
+	return &edgeserverless.Route{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name},
+		Spec: edgeserverless.RouteSpec{
+			URI:       uri,
+			MatchType: matchType,
+			Targets:   []edgeserverless.RouteTarget{{Target: target, Type: "k8sservice", Ratio: 1}},
+		},
+	}
+}
+
+// TestSameURIOtherMatchType checks that routes with the same uri and other
+// match types are loaded side by side through adds, updates and deletes.
+func TestSameURIOtherMatchType(t *testing.T) {
+	c := newTestController()
+	exact := newRoute("exact", "a.com/x", edgeserverless.MatchExact, "exact")
+	prefix := newRoute("prefix", "a.com/x", edgeserverless.MatchPrefix, "prefix")
+	regex := newRoute("regex", "a.com/x", edgeserverless.MatchRegex, "regex")
+	updated := prefix.DeepCopy()
+	updated.Spec.Targets[0].Target = "prefix-v2"
+
+	tests := []struct {
+		name   string
+		route  *edgeserverless.Route
+		delete bool
+		// want maps request paths to the target serving them, "" when none
+		want map[string]string
+	}{
+		{name: "add exact", route: exact, want: map[string]string{"/x": "exact", "/x/y": ""}},
+		{name: "add prefix", route: prefix, want: map[string]string{"/x": "exact", "/x/y": "prefix"}},
+		{name: "add regex", route: regex, want: map[string]string{"/x": "exact", "/x/y": "prefix", "/xy": "regex"}},
+		{name: "update prefix", route: updated, want: map[string]string{"/x": "exact", "/x/y": "prefix-v2"}},
+		{name: "delete exact", route: exact, delete: true, want: map[string]string{"/x": "prefix-v2", "/xy": "regex"}},
+		{name: "delete regex", route: regex, delete: true, want: map[string]string{"/x": "prefix-v2", "/xy": ""}},
+		{name: "delete prefix", route: updated, delete: true, want: map[string]string{"/x": "", "/x/y": ""}},
+	}
+	for _, tt := range tests {
+		c.apply(t, tt.route, tt.delete)
+		for path, want := range tt.want {
+			got := ""
+			if m, err := c.rulesManager.Match("a.com", path); err == nil {
+				got = m.Spec.Targets[0].Target
+			}
+			if got != want {
+				t.Errorf("%s: %s served by %q, want %q", tt.name, path, got, want)
+			}
+		}
+		if value, ok := c.routeToURI.Load(routeKey(tt.route)); ok == tt.delete {
+			t.Errorf("%s: route owns %v, want owned %v", tt.name, value, !tt.delete)
+		}
+	}
+	if n := c.rulesManager.Len(); n != 0 {
+		t.Errorf("%d rules left loaded", n)
+	}
+}
@@ -8,21 +8,27 @@ import (
 	"github.com/golang/glog"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	edgeserverless "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/certs"
 	clientset "github.com/seveirbian/edgeserverless/pkg/client/clientset/versioned"
 	routescheme "github.com/seveirbian/edgeserverless/pkg/client/clientset/versioned/scheme"
 	informers "github.com/seveirbian/edgeserverless/pkg/client/informers/externalversions/edgeserverless/v1alpha1"
 	listers "github.com/seveirbian/edgeserverless/pkg/client/listers/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/health"
+	"github.com/seveirbian/edgeserverless/pkg/metrics"
 	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
 )
 
@@ -43,20 +49,44 @@ type RouteController struct {
 
 	routesSynced cache.InformerSynced
 
+	// secretsLister and certs are nil when the proxy does not terminate TLS
+	secretsLister corelisters.SecretLister
+	secretsSynced cache.InformerSynced
+	certs         *certs.Store
+
 	workQueue workqueue.RateLimitingInterface
 
 	recorder record.EventRecorder
 
+	// routeToURI maps a route key to the claim it owns in the rules table
+	// and uriToRoute maps the uri key of the claim back to the route key.
+	// Both are guarded by ownerLock.
 	routeToURI   sync.Map
+	uriToRoute   sync.Map
+	ownerLock    sync.Mutex
 	rulesManager *rulesmanager.RulesManager
+	health       *health.Manager
+
+	// proxyName identifies this route-proxy instance in RouteStatus.Proxies
+	proxyName string
+
+	// synced and unloaded track readiness, see Loaded
+	readyLock sync.Mutex
+	synced    bool
+	unloaded  map[string]bool
 }
 
-// NewRouteController returns a new route controller
+// NewRouteController returns a new route controller. secretInformer and
+// certStore may be nil, in which case the TLS Secrets of routes are ignored.
 func NewRouteController(
 	kubeClientSet kubernetes.Interface,
 	routeClientSet clientset.Interface,
 	routeInformer informers.RouteInformer,
-	rulesManager *rulesmanager.RulesManager) *RouteController {
+	secretInformer coreinformers.SecretInformer,
+	rulesManager *rulesmanager.RulesManager,
+	healthManager *health.Manager,
+	certStore *certs.Store,
+	proxyName string) *RouteController {
 
 	utilruntime.Must(routescheme.AddToScheme(scheme.Scheme))
 	glog.V(4).Info("Creating event broadcaster")
@@ -73,10 +103,15 @@ func NewRouteController(
 		workQueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Routes"),
 		recorder:       recorder,
 		routeToURI:     sync.Map{},
+		uriToRoute:     sync.Map{},
 		rulesManager:   rulesManager,
+		health:         healthManager,
+		certs:          certStore,
+		proxyName:      proxyName,
 	}
 
 	glog.Info("Setting up event handlers")
+	healthManager.OnChange(controller.enqueueHealthChange)
 	// Set up an event handler for when Route resources change
 	routeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueRoute,
@@ -92,6 +127,20 @@ func NewRouteController(
 		DeleteFunc: controller.enqueueRouteForDelete,
 	})
 
+	if secretInformer != nil && certStore != nil {
+		controller.secretsLister = secretInformer.Lister()
+		controller.secretsSynced = secretInformer.Informer().HasSynced
+		secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: controller.enqueueSecretRoutes,
+			UpdateFunc: func(old, new interface{}) {
+				controller.enqueueSecretRoutes(new)
+			},
+			DeleteFunc: controller.enqueueSecretRoutes,
+		})
+	} else {
+		controller.certs = nil
+	}
+
 	return controller
 }
 
@@ -101,9 +150,16 @@ func (c *RouteController) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer c.workQueue.ShutDown()
 
 	glog.Info("开始controller业务，开始一次缓存数据同步")
-	if ok := cache.WaitForCacheSync(stopCh, c.routesSynced); !ok {
+	synced := []cache.InformerSynced{c.routesSynced}
+	if c.secretsSynced != nil {
+		synced = append(synced, c.secretsSynced)
+	}
+	if ok := cache.WaitForCacheSync(stopCh, synced...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
+	if err := c.markSynced(); err != nil {
+		return err
+	}
 
 	glog.Info("worker启动")
 	for i := 0; i < threadiness; i++ {
@@ -143,9 +199,12 @@ func (c *RouteController) processNextWorkItem() bool {
 			return nil
 		}
 		// 在syncHandler中处理业务
+		start := time.Now()
 		if err := c.syncHandler(key); err != nil {
+			metrics.SyncDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
 			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
 		}
+		metrics.SyncDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
 
 		c.workQueue.Forget(obj)
 		glog.Infof("Successfully synced '%s'", key)
@@ -168,6 +227,9 @@ func (c *RouteController) syncHandler(key string) error {
 		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
 		return nil
 	}
+	// a route that can not be loaded reports it in its status, it does not
+	// keep the proxy from getting ready
+	defer c.markLoaded(key)
 
 	// 从缓存中取对象
 	route, err := c.routesLister.Routes(namespace).Get(name)
@@ -175,20 +237,13 @@ func (c *RouteController) syncHandler(key string) error {
 		// 如果Route对象被删除了，就会走到这里，所以应该在这里加入执行
 		if errors.IsNotFound(err) {
 			glog.Infof("Route对象被删除，请在这里执行实际的删除业务: %s/%s ...", namespace, name)
-			value, ok := c.routeToURI.Load(key)
-			if !ok {
-				fmt.Printf("[route controller] no route %s to uri\n", key)
-				return nil
-			}
-
-			uri, ok := value.(string)
-			if !ok {
-				return fmt.Errorf("[route controller] uri not string\n")
+			c.ownerLock.Lock()
+			c.releaseURI(key)
+			c.ownerLock.Unlock()
+			if c.certs != nil {
+				c.certs.Remove(certSource(key))
 			}
 
-			c.rulesManager.DeleteRule(uri)
-			c.routeToURI.Delete(key)
-
 			return nil
 		}
 
@@ -200,19 +255,57 @@ func (c *RouteController) syncHandler(key string) error {
 	glog.Infof("这里是route对象的期望状态: %#v ...", route)
 	glog.Infof("实际状态是从业务层面得到的，此处应该去的实际状态，与期望状态做对比，并根据差异做出响应(新增或者删除)")
 
-	if value, ok := c.routeToURI.Load(key); ok {
-		uri, ok := value.(string)
-		if !ok {
-			fmt.Printf(fmt.Sprintf("[controller] delete error: not a valid uri string %v\n", value))
-			return fmt.Errorf("[controller] delete error: not a valid uri string %v\n", value)
+	conditions, valid := routeConditions(route)
+
+	claimed := routeClaim(route)
+	winner := ""
+	if valid {
+		if winner, err = c.uriWinner(claimed.uriKey); err != nil {
+			return err
 		}
-		c.rulesManager.DeleteRule(uri)
-		c.routeToURI.Delete(key)
 	}
-	c.rulesManager.AddRule(route.Spec.URI, route.Spec)
-	c.routeToURI.Store(key, route.Spec.URI)
+	owned := valid && winner == key
+
+	c.ownerLock.Lock()
+	if value, ok := c.routeToURI.Load(key); ok && (!owned || value != claimed) {
+		c.releaseURI(key)
+	}
+	var loadErr error
+	if owned {
+		loadErr = c.claimURI(key, route)
+	} else if valid {
+		// make sure the winner picks up the uri
+		if owner, ok := c.uriToRoute.Load(claimed.uriKey); !ok || owner != winner {
+			c.workQueue.Add(winner)
+		}
+	}
+	c.ownerLock.Unlock()
+
+	certErr := c.syncCertificate(key, route, owned)
+
+	conditions = setConflicted(conditions, key, winner)
+
+	targets, checked := c.health.RuleHealth(claimed.uriKey)
+	if err := c.updateRouteStatus(route, conditions, proxyConditions(route, loadErr, targets, checked), owned); err != nil {
+		return fmt.Errorf("[controller] update status of %s error: %v", key, err)
+	}
+
+	if certErr != nil {
+		c.recorder.Event(route, corev1.EventTypeWarning, ReasonCertificateUnavailable, certErr.Error())
+	}
+	switch {
+	case !valid:
+		invalid := meta.FindStatusCondition(conditions, edgeserverless.RouteInvalid)
+		c.recorder.Event(route, corev1.EventTypeWarning, ReasonInvalidSpec, invalid.Message)
+	case !owned:
+		conflicted := meta.FindStatusCondition(conditions, edgeserverless.RouteConflicted)
+		c.recorder.Event(route, corev1.EventTypeWarning, ReasonConflicted, conflicted.Message)
+	case loadErr != nil:
+		c.recorder.Event(route, corev1.EventTypeWarning, ReasonLoadFailed, loadErr.Error())
+	default:
+		c.recorder.Event(route, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+	}
 
-	c.recorder.Event(route, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
 	return nil
 }
 
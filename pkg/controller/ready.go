@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// markSynced records the routes in the cache once it synced, Loaded waits
+// for them to go through syncHandler.
+func (c *RouteController) markSynced() error {
+	routes, err := c.routesLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	c.readyLock.Lock()
+	defer c.readyLock.Unlock()
+
+	c.unloaded = make(map[string]bool, len(routes))
+	for _, r := range routes {
+		c.unloaded[routeKey(r)] = true
+	}
+	c.synced = true
+	return nil
+}
+
+// markLoaded records that key went through syncHandler, whether or not it
+// could be loaded.
+func (c *RouteController) markLoaded(key string) {
+	c.readyLock.Lock()
+	defer c.readyLock.Unlock()
+
+	delete(c.unloaded, key)
+}
+
+// Synced returns an error until the informer caches synced.
+func (c *RouteController) Synced() error {
+	c.readyLock.Lock()
+	defer c.readyLock.Unlock()
+
+	if !c.synced {
+		return fmt.Errorf("informer caches not synced")
+	}
+	return nil
+}
+
+// Loaded returns an error until every route in the cache when it synced was
+// loaded into, or rejected from, the rules table.
+func (c *RouteController) Loaded() error {
+	c.readyLock.Lock()
+	defer c.readyLock.Unlock()
+
+	if !c.synced {
+		return fmt.Errorf("informer caches not synced")
+	}
+	if n := len(c.unloaded); n > 0 {
+		return fmt.Errorf("%d routes not loaded yet", n)
+	}
+	return nil
+}
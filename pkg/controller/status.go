@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	edgeserverless "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+	"github.com/seveirbian/edgeserverless/pkg/health"
+	"github.com/seveirbian/edgeserverless/pkg/validation"
+)
+
+const (
+	ReasonSynced                 = "Synced"
+	ReasonInvalidSpec            = "InvalidSpec"
+	ReasonValidSpec              = "ValidSpec"
+	ReasonBackendsRegistered     = "BackendsRegistered"
+	ReasonBackendNotFound        = "BackendNotFound"
+	ReasonNotLoaded              = "NotLoaded"
+	ReasonConflicted             = "Conflicted"
+	ReasonURIOwned               = "URIOwned"
+	ReasonLoaded                 = "Loaded"
+	ReasonLoadFailed             = "LoadFailed"
+	ReasonCertificateUnavailable = "CertificateUnavailable"
+	ReasonNotChecked             = "NotChecked"
+	ReasonTargetsHealthy         = "TargetsHealthy"
+	ReasonTargetsUnhealthy       = "TargetsUnhealthy"
+)
+
+// healthStatusDelay is how long changes of target health are gathered
+// before the status of their route is written, so flapping targets do not
+// cost a write each.
+const healthStatusDelay = 10 * time.Second
+
+// routeConditions checks the route spec and returns the conditions to
+// report, plus whether the route should be loaded. They only depend on the
+// route, so every proxy reports the same.
+func routeConditions(route *edgeserverless.Route) ([]metav1.Condition, bool) {
+	invalid := metav1.Condition{
+		Type:   edgeserverless.RouteInvalid,
+		Status: metav1.ConditionFalse,
+		Reason: ReasonValidSpec,
+	}
+	if err := validation.ValidateRouteSpec(&route.Spec); err != nil {
+		invalid.Status = metav1.ConditionTrue
+		invalid.Reason = ReasonInvalidSpec
+		invalid.Message = err.Error()
+	}
+
+	ready := metav1.Condition{
+		Type:   edgeserverless.RouteReady,
+		Status: metav1.ConditionTrue,
+		Reason: ReasonSynced,
+	}
+	if invalid.Status == metav1.ConditionTrue {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = ReasonNotLoaded
+		ready.Message = invalid.Message
+	}
+
+	return []metav1.Condition{ready, invalid}, invalid.Status == metav1.ConditionFalse
+}
+
+// proxyConditions checks the route against the backends of this proxy and
+// returns the conditions of its entry in RouteStatus.Proxies, given the
+// error loading it into the rules table and the health of its targets,
+// checked when it has a health check.
+func proxyConditions(route *edgeserverless.Route, loadErr error,
+	targets health.RuleHealth, checked bool) []metav1.Condition {
+	loaded := metav1.Condition{
+		Type:   edgeserverless.RouteLoaded,
+		Status: metav1.ConditionTrue,
+		Reason: ReasonLoaded,
+	}
+	if loadErr != nil {
+		loaded.Status = metav1.ConditionFalse
+		loaded.Reason = ReasonLoadFailed
+		loaded.Message = strings.TrimSpace(loadErr.Error())
+	}
+
+	unavailable := metav1.Condition{
+		Type:   edgeserverless.RouteBackendUnavailable,
+		Status: metav1.ConditionFalse,
+		Reason: ReasonBackendsRegistered,
+	}
+	for _, t := range route.Spec.AllTargets() {
+		if _, err := backend.GetBackend(t.Type); err != nil {
+			unavailable.Status = metav1.ConditionTrue
+			unavailable.Reason = ReasonBackendNotFound
+			unavailable.Message = fmt.Sprintf("backend %q of target %q is not registered", t.Type, t.Target)
+			break
+		}
+	}
+
+	healthy := metav1.Condition{
+		Type:    edgeserverless.RouteTargetsHealthy,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonNotChecked,
+		Message: "route has no health check",
+	}
+	switch {
+	case !checked:
+	case targets.Unhealthy == 0:
+		healthy.Reason = ReasonTargetsHealthy
+		healthy.Message = fmt.Sprintf("%d targets healthy", targets.Targets)
+	default:
+		healthy.Status = metav1.ConditionFalse
+		healthy.Reason = ReasonTargetsUnhealthy
+		healthy.Message = fmt.Sprintf("%d of %d targets unhealthy, %d ejected",
+			targets.Unhealthy, targets.Targets, targets.Ejected)
+	}
+
+	return []metav1.Condition{loaded, unavailable, healthy}
+}
+
+// enqueueHealthChange syncs the route with key once healthStatusDelay has
+// passed, the health changes made meanwhile are written together.
+func (c *RouteController) enqueueHealthChange(key string) {
+	c.workQueue.AddAfter(key, healthStatusDelay)
+}
+
+// setConflicted adds the Conflicted condition for the route with key, given
+// the key of the route that won its uri, and marks it not ready if it lost.
+// An empty winner means no ownership was decided and reports no conflict.
+func setConflicted(conditions []metav1.Condition, key, winner string) []metav1.Condition {
+	conflicted := metav1.Condition{
+		Type:   edgeserverless.RouteConflicted,
+		Status: metav1.ConditionFalse,
+		Reason: ReasonURIOwned,
+	}
+	if winner != "" && winner != key {
+		conflicted.Status = metav1.ConditionTrue
+		conflicted.Reason = ReasonConflicted
+		conflicted.Message = fmt.Sprintf("uri is already claimed by route %s", winner)
+
+		ready := meta.FindStatusCondition(conditions, edgeserverless.RouteReady)
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = conflicted.Reason
+		ready.Message = conflicted.Message
+	}
+
+	return append(conditions, conflicted)
+}
+
+// updateRouteStatus writes the conditions observed by this proxy to the
+// status subresource. Nothing is written when the status would not change,
+// so that our own update does not trigger another sync. The health of each
+// target is served on /debug/health, the status only counts them.
+func (c *RouteController) updateRouteStatus(route *edgeserverless.Route, conditions []metav1.Condition,
+	proxyConditions []metav1.Condition, owned bool) error {
+	current := route
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if current == nil {
+			var err error
+			current, err = c.routeClientSet.EdgeserverlessV1alpha1().Routes(route.Namespace).
+				Get(context.TODO(), route.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+		}
+
+		newRoute := current.DeepCopy()
+		current = nil
+
+		if !setRouteStatus(&newRoute.Status, newRoute.Generation, conditions,
+			c.proxyName, proxyConditions, owned) {
+			return nil
+		}
+
+		_, err := c.routeClientSet.EdgeserverlessV1alpha1().Routes(newRoute.Namespace).
+			UpdateStatus(context.TODO(), newRoute, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// setRouteStatus applies conditions to status, and proxyConditions to the
+// entry of the proxy named proxyName in status.Proxies, which is there while
+// the route owns its uri. Entries of other proxies are left alone. It
+// reports whether anything changed.
+func setRouteStatus(status *edgeserverless.RouteStatus, generation int64, conditions []metav1.Condition,
+	proxyName string, proxyConditions []metav1.Condition, owned bool) bool {
+	changed := status.ObservedGeneration != generation
+	status.ObservedGeneration = generation
+	if setConditions(&status.Conditions, conditions, generation) {
+		changed = true
+	}
+
+	i := 0
+	for i < len(status.Proxies) && status.Proxies[i].Name != proxyName {
+		i++
+	}
+	found := i < len(status.Proxies)
+	if !owned {
+		if found {
+			status.Proxies = append(status.Proxies[:i:i], status.Proxies[i+1:]...)
+			changed = true
+		}
+		return changed
+	}
+
+	proxy := edgeserverless.ProxyStatus{Name: proxyName}
+	if found {
+		status.Proxies[i].DeepCopyInto(&proxy)
+	}
+	if setConditions(&proxy.Conditions, proxyConditions, generation) || !found {
+		now := metav1.Now()
+		proxy.LastSyncTime = &now
+		changed = true
+	}
+	if found {
+		status.Proxies[i] = proxy
+	} else {
+		status.Proxies = append(status.Proxies, proxy)
+		sort.Slice(status.Proxies, func(i, j int) bool {
+			return status.Proxies[i].Name < status.Proxies[j].Name
+		})
+	}
+	return changed
+}
+
+// setConditions sets conditions in list and reports whether any of them
+// changed.
+func setConditions(list *[]metav1.Condition, conditions []metav1.Condition, generation int64) bool {
+	changed := false
+	for _, cond := range conditions {
+		cond.ObservedGeneration = generation
+		old := meta.FindStatusCondition(*list, cond.Type)
+		if old == nil || old.Status != cond.Status || old.Reason != cond.Reason ||
+			old.Message != cond.Message || old.ObservedGeneration != cond.ObservedGeneration {
+			changed = true
+		}
+		meta.SetStatusCondition(list, cond)
+	}
+	return changed
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	edgeserverless "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+)
+
+// TestTargetsHealthyCondition checks that an ejected target is counted in
+// the status of the proxy, once the delayed sync it triggers ran.
+func TestTargetsHealthyCondition(t *testing.T) {
+	c := newTestController()
+	c.health.OnChange(c.enqueueHealthChange)
+	route := newRoute("route", "a.com/x", edgeserverless.MatchPrefix, "a")
+	route.Spec.HealthCheck = &edgeserverless.HealthCheck{ConsecutiveFailures: 1}
+
+	condition := func() *metav1.Condition {
+		current, err := c.routeClientSet.EdgeserverlessV1alpha1().Routes(route.Namespace).
+			Get(context.TODO(), route.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(current.Status.Proxies) != 1 {
+			t.Fatalf("got proxies %v, want proxy", current.Status.Proxies)
+		}
+		return meta.FindStatusCondition(current.Status.Proxies[0].Conditions, edgeserverless.RouteTargetsHealthy)
+	}
+
+	c.apply(t, route, false)
+	if got := condition(); got == nil || got.Status != metav1.ConditionTrue || got.Reason != ReasonTargetsHealthy {
+		t.Fatalf("got %+v, want healthy targets", got)
+	}
+
+	rule := rulesmanager.URIKey(route.Spec.URI, route.Spec.MatchType)
+	c.health.Report(rule, route.Spec.Targets[0], false)
+	if n := c.workQueue.Len(); n != 0 {
+		t.Errorf("%d routes queued right after the ejection, want the sync delayed", n)
+	}
+
+	if err := c.syncHandler(routeKey(route)); err != nil {
+		t.Fatal(err)
+	}
+	want := "1 of 1 targets unhealthy, 1 ejected"
+	if got := condition(); got == nil || got.Status != metav1.ConditionFalse || got.Message != want {
+		t.Errorf("got %+v, want unhealthy with message %q", got, want)
+	}
+}
@@ -0,0 +1,261 @@
+package entry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/breaker"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+)
+
+// RuleStatus is a loaded rule as served by ServeRules.
+type RuleStatus struct {
+	rulesmanager.Rule
+	// Targets are the route's default targets, Matches[i] the targets of
+	// spec.matches[i].
+	Targets []TargetStatus   `json:"targets"`
+	Matches [][]TargetStatus `json:"matches,omitempty"`
+}
+
+// TargetStatus is a target of a rule and its share of the requests.
+type TargetStatus struct {
+	v1alpha1.RouteTarget
+	// Share is the fraction of requests the target gets among the targets
+	// it is picked from, 0 when it is skipped.
+	Share float64 `json:"share"`
+	// Skipped tells why the target is not picked, e.g. "unhealthy".
+	Skipped string `json:"skipped,omitempty"`
+	// Circuit is the state of the circuit breaker of the target, if any.
+	Circuit *breaker.Status `json:"circuit,omitempty"`
+}
+
+// MatchResult is the answer of ServeMatch.
+type MatchResult struct {
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	Method   string `json:"method"`
+	Listener string `json:"listener,omitempty"`
+	ClientIP string `json:"clientIP,omitempty"`
+
+	// Status is the status the entry answers with when no target is
+	// invoked, 200 when one is.
+	Status int `json:"status"`
+	// Reasons explain, step by step, how the request was routed.
+	Reasons []string `json:"reasons"`
+
+	Rule *rulesmanager.Rule `json:"rule,omitempty"`
+	// Match is the index in spec.matches serving the request, -1 for the
+	// route's default targets.
+	Match   int               `json:"match"`
+	Params  map[string]string `json:"params,omitempty"`
+	Targets []TargetStatus    `json:"targets,omitempty"`
+	// Target is one pick among Targets, picks are random by ratio unless
+	// the route has a session affinity.
+	Target *v1alpha1.RouteTarget `json:"target,omitempty"`
+}
+
+// ServeRules serves the loaded rules with the health and share of their
+// targets as JSON.
+func (e *Entry) ServeRules(w http.ResponseWriter, r *http.Request) {
+	rules := e.RulesManager.List()
+	statuses := make([]RuleStatus, 0, len(rules))
+	for _, rule := range rules {
+		status := RuleStatus{
+			Rule:    rule,
+			Targets: e.targetStatuses(rule.Key, rule.Breakers, rule.Spec.Targets),
+		}
+		for _, m := range rule.Spec.Matches {
+			status.Matches = append(status.Matches, e.targetStatuses(rule.Key, rule.Breakers, m.Targets))
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// ServeMatch routes a request described by query parameters without
+// invoking any target and serves the result as JSON:
+//
+//	host      Host of the request, required
+//	path      path, with a query string, "/" by default
+//	method    GET by default
+//	header    "Name: Value", may be repeated, cookies go in a Cookie header
+//	listener  name of the listener the request arrives on, none checked
+//	          when empty
+//	clientIP  address of the client, for session affinity by clientIP
+func (e *Entry) ServeMatch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	result := MatchResult{
+		Host:     q.Get("host"),
+		Path:     q.Get("path"),
+		Method:   strings.ToUpper(q.Get("method")),
+		Listener: q.Get("listener"),
+		ClientIP: q.Get("clientIP"),
+		Match:    -1,
+	}
+	if result.Path == "" {
+		result.Path = "/"
+	}
+	if result.Method == "" {
+		result.Method = http.MethodGet
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	req, err := newDryRequest(result.Method, result.Path, q["header"])
+	if err == nil && result.Host == "" {
+		err = fmt.Errorf("host is required")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Code: "BadRequest", Message: err.Error()})
+		return
+	}
+
+	e.dryRun(&result, req)
+	json.NewEncoder(w).Encode(result)
+}
+
+// dryRun follows the steps of serve up to picking a target.
+func (e *Entry) dryRun(result *MatchResult, req dryRequest) {
+	path := result.Path
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	match, err := e.RulesManager.Match(result.Host, path)
+	if err != nil {
+		result.Status = http.StatusNotFound
+		result.Reasons = append(result.Reasons, strings.TrimSpace(err.Error()))
+		return
+	}
+	result.Rule = &rulesmanager.Rule{URI: match.URI, Key: match.Key, Spec: match.Spec, Source: match.Source}
+	result.Params = match.Params
+	matchType := match.Spec.MatchType
+	if matchType == "" {
+		matchType = v1alpha1.MatchExact
+	}
+	result.Reasons = append(result.Reasons, fmt.Sprintf("%s rule %s of route %s/%s matches %s%s",
+		matchType, match.URI, match.Source.Namespace, match.Source.Name, result.Host, path))
+
+	if result.Listener != "" && !servedOn(match.Spec.Listeners, result.Listener) {
+		result.Status = http.StatusNotFound
+		result.Reasons = append(result.Reasons, fmt.Sprintf("route is only served on listeners %s",
+			strings.Join(match.Spec.Listeners, ", ")))
+		return
+	}
+
+	result.Match, err = match.Selected(req)
+	switch {
+	case err == rulesmanager.ErrMethodNotAllowed:
+		result.Status = http.StatusMethodNotAllowed
+		result.Reasons = append(result.Reasons, fmt.Sprintf("method %s is not one of %s",
+			result.Method, strings.Join(match.AllowedMethods(), ", ")))
+		return
+	case err != nil:
+		result.Status = http.StatusNotFound
+		result.Reasons = append(result.Reasons, "no match holds and the route has no default targets")
+		return
+	case result.Match >= 0:
+		result.Reasons = append(result.Reasons, fmt.Sprintf("matches[%d] holds", result.Match))
+	case len(match.Spec.Matches) > 0:
+		result.Reasons = append(result.Reasons, "no match holds, using the default targets")
+	}
+
+	targets, ring := match.Targets(result.Match)
+	aff := newAffinity(match.Spec.SessionAffinity, ring, targets, req, result.ClientIP)
+	result.Targets = e.targetStatuses(match.Key, match.Breakers, targets)
+	i, err := e.pickTarget(match.Key, match.Breakers, targets,
+		make([]bool, len(targets)), make([]bool, len(targets)), aff)
+	if err != nil {
+		result.Status = http.StatusServiceUnavailable
+		result.Reasons = append(result.Reasons, fmt.Sprintf("no target is available: %v", err))
+		return
+	}
+	result.Status = http.StatusOK
+	result.Target = &targets[i]
+	result.Reasons = append(result.Reasons, fmt.Sprintf("target %s picked %s, with a share of %.2f",
+		targets[i].Target, aff.reason(i), result.Targets[i].Share))
+}
+
+// targetStatuses returns the status of targets of rule, shares add up to 1
+// among the targets pickTarget does not skip.
+func (e *Entry) targetStatuses(rule string, breakers *breaker.Set,
+	targets []v1alpha1.RouteTarget) []TargetStatus {
+	statuses := make([]TargetStatus, len(targets))
+	var total int64
+	for i, t := range targets {
+		statuses[i] = TargetStatus{RouteTarget: t, Skipped: e.unavailable(rule, breakers, t)}
+		if b := breakers.Lookup(t); b != nil {
+			circuit := b.Status()
+			statuses[i].Circuit = &circuit
+		}
+		if statuses[i].Skipped == "" {
+			total += t.Ratio
+		}
+	}
+	for i := range statuses {
+		if statuses[i].Skipped == "" && total > 0 {
+			statuses[i].Share = float64(statuses[i].Ratio) / float64(total)
+		}
+	}
+	return statuses
+}
+
+// dryRequest exposes a request described to ServeMatch to rulesmanager
+// match predicates.
+type dryRequest struct {
+	method string
+	header http.Header
+	query  url.Values
+}
+
+func newDryRequest(method, path string, headers []string) (dryRequest, error) {
+	req := dryRequest{method: method, header: http.Header{}}
+	for _, h := range headers {
+		i := strings.IndexByte(h, ':')
+		if i <= 0 {
+			return req, fmt.Errorf("header %q is not \"Name: Value\"", h)
+		}
+		req.header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	var err error
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		if req.query, err = url.ParseQuery(path[i+1:]); err != nil {
+			return req, fmt.Errorf("path %q: %v", path, err)
+		}
+	}
+	return req, nil
+}
+
+func (r dryRequest) Method() string {
+	return r.method
+}
+
+func (r dryRequest) Header(name string) (string, bool) {
+	v, ok := r.header[textproto.CanonicalMIMEHeaderKey(name)]
+	if !ok {
+		return "", false
+	}
+	return v[0], true
+}
+
+func (r dryRequest) Query(name string) (string, bool) {
+	v, ok := r.query[name]
+	if !ok {
+		return "", false
+	}
+	return v[0], true
+}
+
+func (r dryRequest) Cookie(name string) (string, bool) {
+	c, err := (&http.Request{Header: r.header}).Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	return c.Value, true
+}
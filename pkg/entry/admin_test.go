@@ -0,0 +1,80 @@
+package entry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+	"github.com/seveirbian/edgeserverless/pkg/breaker"
+)
+
+// TestServeMatchLeavesBreakers checks that polling /debug/match neither
+// creates breakers, moves an open one to half-open nor takes its trial
+// request.
+func TestServeMatchLeavesBreakers(t *testing.T) {
+	registerK8sService(t)
+	const openTime = 20 * time.Millisecond
+	failing := v1alpha1.RouteTarget{Target: "http://failing", Type: backend.K8sServiceBackendType, Ratio: 1}
+	idle := v1alpha1.RouteTarget{Target: "http://idle", Type: backend.K8sServiceBackendType, Ratio: 1}
+	e := newServingEntry(t, v1alpha1.RouteSpec{
+		URI:     "a.com/fn",
+		Targets: []v1alpha1.RouteTarget{failing, idle},
+		CircuitBreaker: &v1alpha1.CircuitBreaker{
+			ErrorRate:   50,
+			MinRequests: 1,
+			OpenTime:    &metav1.Duration{Duration: openTime},
+		},
+	})
+	match, err := e.RulesManager.Match("a.com", "/fn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := match.Breakers.Get(failing)
+	done, err := b.Acquire(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(false)
+
+	poll := func() MatchResult {
+		w := httptest.NewRecorder()
+		e.ServeMatch(w, httptest.NewRequest(http.MethodGet, "/debug/match?host=a.com&path=/fn", nil))
+		var result MatchResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if got := poll().Targets[0]; got.Skipped != skippedCircuitOpen {
+		t.Errorf("open target skipped for %q, want %q", got.Skipped, skippedCircuitOpen)
+	}
+	time.Sleep(openTime)
+	for i := 0; i < 3; i++ {
+		got := poll().Targets[0]
+		if got.Skipped != "" || got.Circuit == nil || got.Circuit.State != breaker.StateHalfOpen {
+			t.Fatalf("poll %d: got %+v, want a half-open target", i, got)
+		}
+	}
+	if match.Breakers.Lookup(idle) != nil {
+		t.Errorf("breaker created for the idle target")
+	}
+
+	trial, err := b.Acquire(time.Time{})
+	if err != nil {
+		t.Fatalf("trial request after polling: %v", err)
+	}
+	if _, err := b.Acquire(time.Time{}); err != breaker.ErrOpen {
+		t.Errorf("second request while half-open: got %v, want %v", err, breaker.ErrOpen)
+	}
+	trial(true)
+	if opens := b.Status().Opens; opens != 1 {
+		t.Errorf("opened %d times, want 1", opens)
+	}
+}
@@ -0,0 +1,112 @@
+package entry
+
+import (
+	"fmt"
+
+	fiber "github.com/gofiber/fiber/v2"
+
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/hashring"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+)
+
+// affinity is the session affinity of one request, a nil affinity leaves
+// the pick to chance.
+type affinity struct {
+	spec *v1alpha1.SessionAffinity
+	ring *hashring.Ring
+	// key is hashed onto ring when hasKey
+	key    string
+	hasKey bool
+	// pinned is the target named by the affinity cookie, -1 when none is
+	pinned int
+}
+
+func newAffinity(spec *v1alpha1.SessionAffinity, ring *hashring.Ring, targets []v1alpha1.RouteTarget,
+	req rulesmanager.Request, clientIP string) *affinity {
+	if spec == nil {
+		return nil
+	}
+
+	a := &affinity{spec: spec, ring: ring, pinned: -1}
+	switch spec.Key {
+	case v1alpha1.AffinityByClientIP:
+		a.key, a.hasKey = clientIP, clientIP != ""
+	case v1alpha1.AffinityByHeader:
+		a.key, a.hasKey = req.Header(spec.Name)
+	case v1alpha1.AffinityByCookie:
+		a.key, a.hasKey = req.Cookie(spec.Name)
+	case v1alpha1.AffinityByQuery:
+		a.key, a.hasKey = req.Query(spec.Name)
+	}
+	if spec.Cookie != nil {
+		if value, ok := req.Cookie(spec.Cookie.Name); ok {
+			for i, t := range targets {
+				if affinityValue(t) == value {
+					a.pinned = i
+					break
+				}
+			}
+		}
+	}
+	return a
+}
+
+// pick returns the target of the request among those ok returns true for:
+// the one named by the cookie, else the one key hashes to. It returns -1
+// when neither decides.
+func (a *affinity) pick(ok func(i int) bool) int {
+	if a == nil {
+		return -1
+	}
+	if a.pinned >= 0 && ok(a.pinned) {
+		return a.pinned
+	}
+	if a.ring != nil && a.hasKey {
+		return a.ring.Pick(a.key, ok)
+	}
+	return -1
+}
+
+// reason tells how pick chose target i.
+func (a *affinity) reason(i int) string {
+	switch {
+	case a == nil:
+		return "at random by ratio"
+	case a.pinned == i:
+		return fmt.Sprintf("by the affinity cookie %s", a.spec.Cookie.Name)
+	case a.ring != nil && a.hasKey:
+		return fmt.Sprintf("by the hash of %s %s", a.spec.Key, a.spec.Name)
+	}
+	return "at random by ratio, the request has no affinity key"
+}
+
+// setCookie issues the affinity cookie naming target i, unless the request
+// already sent it.
+func (a *affinity) setCookie(c *fiber.Ctx, targets []v1alpha1.RouteTarget, i int) {
+	if a == nil || a.spec.Cookie == nil || a.pinned == i {
+		return
+	}
+
+	cookie := &fiber.Cookie{
+		Name:     a.spec.Cookie.Name,
+		Value:    affinityValue(targets[i]),
+		Path:     a.spec.Cookie.Path,
+		Secure:   scheme(c) == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if a.spec.Cookie.TTL != nil {
+		cookie.MaxAge = int(a.spec.Cookie.TTL.Seconds())
+	}
+	c.Cookie(cookie)
+}
+
+// affinityValue identifies target in the affinity cookie without revealing
+// its address.
+func affinityValue(target v1alpha1.RouteTarget) string {
+	return fmt.Sprintf("%016x", hashring.Hash(target.Type+"/"+target.Target))
+}
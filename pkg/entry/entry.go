@@ -1,86 +1,419 @@
 package entry
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	wr "github.com/mroth/weightedrand"
+	"github.com/seveirbian/edgeserverless/pkg/accesslog"
 	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
 	"github.com/seveirbian/edgeserverless/pkg/backend"
+	"github.com/seveirbian/edgeserverless/pkg/breaker"
+	"github.com/seveirbian/edgeserverless/pkg/certs"
+	"github.com/seveirbian/edgeserverless/pkg/health"
+	"github.com/seveirbian/edgeserverless/pkg/ratelimit"
 	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+	"github.com/seveirbian/edgeserverless/pkg/tracing"
 	"github.com/valyala/fasthttp"
-	"math/rand"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Entry struct {
 	Server *fiber.App
-	Addr   string
+	// Listeners are the addresses the entry serves, one HTTP listener on
+	// :1122 by default.
+	Listeners []Listener
+	// Admin is served on admin listeners.
+	Admin http.Handler
+	// TLSConfig sets the versions and cipher suites of HTTPS listeners.
+	TLSConfig *tls.Config
+	// Certs holds the certificates of HTTPS listeners.
+	Certs *certs.Store
+	// RedirectHTTP redirects requests on HTTP listeners to the first HTTPS
+	// listener.
+	RedirectHTTP bool
+	// DefaultTimeout bounds requests to routes without a timeout of their
+	// own, none when zero.
+	DefaultTimeout time.Duration
 
 	RulesManager *rulesmanager.RulesManager
-	HTTPClient   *fasthttp.Client
+	Health       *health.Manager
+	// AccessLog receives a record per proxied request, nil disables it.
+	AccessLog *accesslog.Logger
+	// Tracer traces proxied requests, nil disables tracing.
+	Tracer *tracing.Tracer
+	// JWTVerifier checks the bearer tokens of rate limits keyed by
+	// jwtClaim. Without it their claims are not trusted and the requests
+	// share one bucket.
+	JWTVerifier *JWTVerifier
+
+	adminServers []*http.Server
+	draining     int32
+	inflight     int64
+	connsLock    sync.Mutex
+	idleConns    map[net.Conn]struct{}
+
+	// h2Server serves the HTTPS connections negotiating h2, h2Base lets
+	// Shutdown send them a GOAWAY
+	h2Server *http2.Server
+	h2Base   *http.Server
 }
 
-func NewEntry(rulesManager *rulesmanager.RulesManager) *Entry {
-	app := fiber.New()
+func NewEntry(rulesManager *rulesmanager.RulesManager, healthManager *health.Manager) *Entry {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: errorHandler,
+		// no proxy is trusted, so routes are matched on the host the entry
+		// received rather than on an X-Forwarded-Host sent by the client
+		EnableTrustedProxyCheck: true,
+	})
+	app.Use(recover.New())
+	app.Use(requestid.New(requestid.Config{
+		ContextKey: requestIDKey,
+	}))
 
-	client := &fasthttp.Client{
-		NoDefaultUserAgentHeader: true,
-		DisablePathNormalizing:   true,
+	e := &Entry{
+		Server:         app,
+		Listeners:      []Listener{{Name: ListenerHTTP, Kind: ListenerHTTP, Addr: ":1122"}},
+		DefaultTimeout: 30 * time.Second,
+		RulesManager:   rulesManager,
+		Health:         healthManager,
+		idleConns:      map[net.Conn]struct{}{},
 	}
+	// answer with Connection: close while shutting down and track idle
+	// connections, so Shutdown does not wait on keep-alive clients
+	app.Server().CloseOnShutdown = true
+	app.Server().ConnState = e.trackConn
 
-	return &Entry{
-		Server:       app,
-		Addr:         ":1122",
-		RulesManager: rulesManager,
-		HTTPClient:   client,
-	}
+	return e
 }
 
-func (e *Entry) Start() {
-	e.Server.Get("/", e.healthCheck)
-	e.Server.Get("/*", e.serve)
-	e.Server.Post("/*", e.serve)
+// Start opens all listeners and serves them in the background. Nothing is
+// served when one of them can not be opened.
+func (e *Entry) Start() error {
+	if err := ValidateListeners(e.Listeners); err != nil {
+		return fmt.Errorf("[entry] %v", err)
+	}
+	for _, l := range e.Listeners {
+		if l.Kind == ListenerHTTPS && e.Certs == nil {
+			return fmt.Errorf("[entry] https listener %s has no certificates", l.Name)
+		}
+	}
 
-	err := e.Server.Listen(e.Addr)
-	if err != nil {
-		fmt.Printf("[entry] exit with %v\n", err)
+	if e.RedirectHTTP && e.httpsListener() != nil {
+		e.Server.Use(e.redirectHTTP)
 	}
-}
+	e.Server.All("/*", e.serve)
 
-func (e *Entry) healthCheck(c *fiber.Ctx) error {
-	c.Response().SetStatusCode(200)
+	e.h2Server = &http2.Server{}
+	e.h2Base = &http.Server{}
+	if err := http2.ConfigureServer(e.h2Base, e.h2Server); err != nil {
+		return fmt.Errorf("[entry] http2 error: %v", err)
+	}
+
+	lns := make([]net.Listener, 0, len(e.Listeners))
+	for _, l := range e.Listeners {
+		ln, err := listen(l)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return fmt.Errorf("[entry] listen %s on %s error: %v", l.Name, l.Addr, err)
+		}
+		lns = append(lns, ln)
+	}
 
+	admin := e.Admin
+	if admin == nil {
+		admin = http.NotFoundHandler()
+	}
+	for i, l := range e.Listeners {
+		var server *http.Server
+		if l.Kind == ListenerAdmin {
+			server = &http.Server{Handler: admin}
+			e.adminServers = append(e.adminServers, server)
+		}
+		go e.serveListener(l, lns[i], server)
+	}
 	return nil
 }
 
-func (e *Entry) serve(c *fiber.Ctx) error {
-	uri := c.Hostname() + c.Path()
-	fmt.Println(uri)
-	route, err := e.RulesManager.GetRule(uri)
-	if err != nil {
-		return c.SendString(fmt.Sprintf("error %v\n", err))
+// serveListener serves ln, with server when it is an admin listener.
+func (e *Entry) serveListener(l Listener, ln net.Listener, server *http.Server) {
+	fmt.Printf("[entry] %s listener %s on %s\n", l.Kind, l.Name, l.Addr)
+
+	var err error
+	switch l.Kind {
+	case ListenerAdmin:
+		err = server.Serve(ln)
+	case ListenerHTTPS:
+		named := &namedListener{Listener: tls.NewListener(ln, e.tlsConfig()), name: l.Name}
+		err = e.Server.Listener(newH2Listener(named, e.serveH2))
+	default:
+		err = e.Server.Listener(&namedListener{Listener: ln, name: l.Name})
 	}
+	fmt.Printf("[entry] listener %s exit with %v\n", l.Name, err)
+}
 
-	rand.Seed(time.Now().UTC().UnixNano())
+// ParamHeaderPrefix prefixes the request headers carrying path parameters
+// captured by the matched rule, e.g. X-Route-Param-Id for ":id".
+const ParamHeaderPrefix = "X-Route-Param-"
+
+func (e *Entry) serve(c *fiber.Ctx) error {
+	atomic.AddInt64(&e.inflight, 1)
+	defer atomic.AddInt64(&e.inflight, -1)
 
-	choices := []wr.Choice{}
-	for _, t := range route.Targets {
-		choices = append(choices, wr.Choice{Item: t,
-			Weight: uint(t.Ratio)})
+	obs := newObservation(e.AccessLog, c)
+	defer obs.done(c)
+	span := e.startSpan(c)
+	defer endSpan(c, span)
+
+	matchSpan := span.Child("match", tracing.SpanKindInternal)
+	match, err := e.RulesManager.Match(c.Hostname(), c.Path())
+	if err != nil {
+		matchSpan.SetError(err)
+		matchSpan.End()
+		return sendError(c, fiber.StatusNotFound, CodeRouteNotFound, err)
+	}
+	if listener := listenerName(c); !servedOn(match.Spec.Listeners, listener) {
+		matchSpan.End()
+		return sendError(c, fiber.StatusNotFound, CodeRouteNotFound,
+			fmt.Errorf("route of %s is not served on listener %s", match.URI, listener))
 	}
+	obs.namespace, obs.route = match.Source.Namespace, match.Source.Name
+	span.SetAttribute("route.uri", match.URI)
+	span.SetAttribute("route.name", match.Source.Namespace+"/"+match.Source.Name)
 
-	chooser, _ := wr.NewChooser(choices...)
+	selected, err := match.Selected(matchRequest{c})
+	matchSpan.SetError(err)
+	matchSpan.End()
+	switch err {
+	case nil:
+	case rulesmanager.ErrMethodNotAllowed:
+		sendError(c, fiber.StatusMethodNotAllowed, CodeMethodNotAllowed, err)
+		c.Set(fiber.HeaderAllow, strings.Join(match.AllowedMethods(), ", "))
+		return nil
+	default:
+		return sendError(c, fiber.StatusNotFound, CodeRouteNotFound, err)
+	}
 
-	target := chooser.Pick().(v1alpha1.RouteTarget)
-	fmt.Println(target)
+	var limit *ratelimit.Result
+	if match.Limiter != nil {
+		result := match.Limiter.Allow(e.rateLimitKey(c, match.Spec.RateLimit), time.Now())
+		if !result.Allowed {
+			sendError(c, fiber.StatusTooManyRequests, CodeRateLimited,
+				fmt.Errorf("rate limit of %s exceeded", match.URI))
+			setRateLimitHeaders(c, result)
+			return nil
+		}
+		limit = &result
+	}
 
 	req := c.Request()
 	res := c.Response()
 
-	bke, err := backend.GetBackend(target.Type)
+	req.Header.Set(fiber.HeaderXRequestID, requestID(c))
+	for name, value := range match.Params {
+		req.Header.Set(ParamHeaderPrefix+name, value)
+	}
+	vars := newHeaderVars(c, match)
+	targets, ring := match.Targets(selected)
+	aff := newAffinity(match.Spec.SessionAffinity, ring, targets, matchRequest{c}, vars.clientIP)
+	setForwarded(req, vars)
+	// the route header rules are applied to a copy of the request headers on
+	// each try, so a retry does not add its headers twice
+	var header *fasthttp.RequestHeader
+	if match.RequestHeaders != nil {
+		header = &fasthttp.RequestHeader{}
+		req.Header.CopyTo(header)
+	}
+	// backends may change the uri, it is set again before each try
+	upstreamURI := match.RewritePath(c.Path())
+	if query := req.URI().QueryString(); len(query) > 0 {
+		upstreamURI += "?" + string(query)
+	}
+
+	policy := newRetryPolicy(match.Spec.Retry, c.Method())
+	// fasthttp moves the body out of the request when a call times out, a
+	// copy is set again before each retry
+	var body []byte
+	if policy.attempts > 1 {
+		body = append([]byte(nil), req.Body()...)
+	}
+	deadline := e.deadline(c, match.Spec, obs.start)
+	var idleTimeout time.Duration
+	if match.Spec.IdleTimeout != nil {
+		idleTimeout = match.Spec.IdleTimeout.Duration
+	}
+	tried := make([]bool, len(targets))
+	rejected := make([]bool, len(targets))
+	picked := 0
+	for try := 1; ; try++ {
+		timeout, ok := callTimeout(deadline, policy.perTryTimeout)
+		if !ok {
+			return sendError(c, fiber.StatusGatewayTimeout, CodeGatewayTimeout,
+				fmt.Errorf("deadline of %s exceeded after %d tries", match.URI, try-1))
+		}
+
+		selectSpan := span.Child("select target", tracing.SpanKindInternal)
+		i, err := e.pickTarget(match.Key, match.Breakers, targets, tried, rejected, aff)
+		selectSpan.SetError(err)
+		selectSpan.End()
+		if err == errCircuitOpen {
+			return sendError(c, fiber.StatusServiceUnavailable, CodeCircuitOpen,
+				fmt.Errorf("no available target for %s: %v", match.URI, err))
+		} else if err != nil {
+			return sendError(c, fiber.StatusServiceUnavailable, CodeNoHealthyTarget,
+				fmt.Errorf("no available target for %s: %v", match.URI, err))
+		}
+		tried[i] = true
+		picked = i
+		target := targets[i]
+		obs.target, obs.backend = target.Target, target.Type
+
+		bke, err := backend.GetBackend(target.Type)
+		if err != nil {
+			return sendError(c, fiber.StatusServiceUnavailable, CodeNoHealthyTarget, err)
+		}
+
+		// a target without a free slot, or whose breaker just opened, is
+		// left for the others without counting as a try
+		release, err := match.Breakers.Get(target).Acquire(deadline)
+		if err != nil {
+			rejected[i] = true
+			try--
+			continue
+		}
+
+		vars.target = target
+		if try > 1 {
+			req.SetBody(body)
+		}
+		if header != nil {
+			header.CopyTo(&req.Header)
+		}
+		req.SetRequestURI(upstreamURI)
+		match.RequestHeaders.Apply(&req.Header, vars.get)
+
+		invokeSpan := span.Child("invoke "+target.Type, tracing.SpanKindClient)
+		invokeSpan.SetAttribute("backend.name", target.Type)
+		invokeSpan.SetAttribute("backend.target", target.Target)
+		invokeSpan.SetAttribute("retry.attempt", try)
+		inject(req, invokeSpan)
+		if timeout > 0 {
+			req.Header.Set(TimeoutHeader, strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+		}
+
+		res.Reset()
+		err = obs.invoke(bke, target.Target, req, res, timeout, idleTimeout)
+		invokeSpan.SetAttribute("http.status_code", res.StatusCode())
+		invokeSpan.SetError(err)
+		invokeSpan.End()
+		success := err == nil && res.StatusCode() < fiber.StatusInternalServerError
+		release(success)
+		e.Health.Report(match.Key, target, success)
+		// a retry that could not start before the deadline is not made, the
+		// result of this try is returned instead
+		delay := policy.delay(try)
+		if try < policy.attempts && policy.shouldRetry(err, res.StatusCode()) &&
+			(deadline.IsZero() || time.Now().Add(delay).Before(deadline)) {
+			fmt.Printf("[entry] retry %s after try %d on %s: %v %d\n",
+				match.URI, try, target.Target, err, res.StatusCode())
+			time.Sleep(delay)
+			continue
+		}
+
+		if err != nil {
+			return sendBackendError(c, err)
+		}
+		break
+	}
+	c.Set(fiber.HeaderXRequestID, requestID(c))
+	if limit != nil {
+		setRateLimitHeaders(c, *limit)
+	}
+	aff.setCookie(c, targets, picked)
+	match.ResponseHeaders.Apply(&res.Header, vars.get)
+
+	return nil
+}
+
+// errCircuitOpen is returned by pickTarget when the only targets left are
+// short-circuited by their breaker or had no slot for the request.
+var errCircuitOpen = errors.New("targets are short-circuited or at capacity")
+
+// skippedCircuitOpen is returned by unavailable for targets whose breaker
+// is open.
+const skippedCircuitOpen = "circuit open"
+
+// pickTarget picks one of targets of rule, a rule key, at random by ratio,
+// skipping unavailable ones and those rejected by their breaker, so their
+// share goes to the others. Targets not tried yet are preferred, so a retry
+// fails over to another target when there is one. With an affinity, the
+// target it names is picked instead when eligible, the pick falls back to
+// chance only for requests without a key.
+func (e *Entry) pickTarget(rule string, breakers *breaker.Set, targets []v1alpha1.RouteTarget,
+	tried []bool, rejected []bool, aff *affinity) (int, error) {
+	var fresh, all []wr.Choice
+	eligible := make([]bool, len(targets))
+	shortCircuited := false
+	for i, t := range targets {
+		if rejected[i] {
+			shortCircuited = true
+			continue
+		}
+		if reason := e.unavailable(rule, breakers, t); reason != "" {
+			shortCircuited = shortCircuited || reason == skippedCircuitOpen
+			continue
+		}
+		eligible[i] = t.Ratio > 0
+		choice := wr.Choice{Item: i, Weight: uint(t.Ratio)}
+		all = append(all, choice)
+		if !tried[i] {
+			fresh = append(fresh, choice)
+		}
+	}
+
+	if i := aff.pick(func(i int) bool { return eligible[i] && !tried[i] }); i >= 0 {
+		return i, nil
+	}
+	if i := aff.pick(func(i int) bool { return eligible[i] }); i >= 0 {
+		return i, nil
+	}
+
+	chooser, err := wr.NewChooser(fresh...)
 	if err != nil {
-		c.Response().SetStatusCode(500)
+		if chooser, err = wr.NewChooser(all...); err != nil {
+			if shortCircuited {
+				return 0, errCircuitOpen
+			}
+			return 0, err
+		}
 	}
 
-	return bke.Invoke(target.Target, req, res)
+	return chooser.Pick().(int), nil
+}
+
+// unavailable returns why pickTarget skips target t of rule, "" when it does
+// not. It only peeks at the breaker of t, so dry runs change no state.
+func (e *Entry) unavailable(rule string, breakers *breaker.Set, t v1alpha1.RouteTarget) string {
+	if _, err := backend.GetBackend(t.Type); err != nil {
+		return fmt.Sprintf("backend %s is not configured", t.Type)
+	}
+	if !e.Health.Healthy(rule, t) {
+		return "unhealthy"
+	}
+	if _, available := breakers.Lookup(t).Peek(); !available {
+		return skippedCircuitOpen
+	}
+	return ""
 }
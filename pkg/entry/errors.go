@@ -0,0 +1,67 @@
+package entry
+
+import (
+	"errors"
+	"strings"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// Codes in the body of error responses sent by the entry.
+const (
+	CodeRouteNotFound    = "RouteNotFound"
+	CodeMethodNotAllowed = "MethodNotAllowed"
+	CodeRateLimited      = "RateLimited"
+	CodeNoHealthyTarget  = "NoHealthyTarget"
+	CodeCircuitOpen      = "CircuitOpen"
+	CodeBadGateway       = "BadGateway"
+	CodeGatewayTimeout   = "GatewayTimeout"
+	CodeInternal         = "InternalError"
+)
+
+const requestIDKey = "requestid"
+
+// ErrorResponse is the JSON body of every error sent by the entry.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
+}
+
+// sendError replaces whatever the backend may have written with a JSON
+// error response.
+func sendError(c *fiber.Ctx, status int, code string, err error) error {
+	c.Response().Reset()
+	c.Set(fiber.HeaderXRequestID, requestID(c))
+	c.Locals(errorCodeKey, code)
+
+	return c.Status(status).JSON(ErrorResponse{
+		Code:      code,
+		Message:   strings.TrimSpace(err.Error()),
+		RequestID: requestID(c),
+	})
+}
+
+// sendBackendError maps an error returned by Backend.Invoke to 504 for
+// timeouts and 502 for anything else.
+func sendBackendError(c *fiber.Ctx, err error) error {
+	if isTimeout(err) {
+		return sendError(c, fiber.StatusGatewayTimeout, CodeGatewayTimeout, err)
+	}
+	return sendError(c, fiber.StatusBadGateway, CodeBadGateway, err)
+}
+
+// errorHandler renders errors returned by handlers, and panics caught by the
+// recover middleware, as JSON.
+func errorHandler(c *fiber.Ctx, err error) error {
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return sendError(c, fe.Code, CodeInternal, fe)
+	}
+	return sendError(c, fiber.StatusInternalServerError, CodeInternal, err)
+}
+
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDKey).(string)
+	return id
+}
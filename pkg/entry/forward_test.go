@@ -0,0 +1,472 @@
+package entry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/seveirbian/edgeserverless/pkg/accesslog"
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+	"github.com/seveirbian/edgeserverless/pkg/health"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+)
+
+func registerK8sService(t *testing.T) {
+	if _, err := backend.GetBackend(backend.K8sServiceBackendType); err == nil {
+		return
+	}
+	err := backend.NewBackend(backend.Config{
+		Name: backend.K8sServiceBackendType,
+		Type: backend.K8sServiceBackendType,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveOnce sends a request for a.com path through an entry serving spec.
+func serveOnce(t *testing.T, spec v1alpha1.RouteSpec, path string, header map[string]string) *fasthttp.Response {
+	req := &fasthttp.Request{}
+	req.SetRequestURI(path)
+	req.Header.SetHost("a.com")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	return serveRequest(t, spec, req)
+}
+
+// serveRequest sends req through an entry serving spec.
+func serveRequest(t *testing.T, spec v1alpha1.RouteSpec, req *fasthttp.Request) *fasthttp.Response {
+	return send(newServingEntry(t, spec), req)
+}
+
+// newServingEntry returns an entry serving spec.
+func newServingEntry(t *testing.T, spec v1alpha1.RouteSpec) *Entry {
+	rm := rulesmanager.NewRulesManager()
+	source := rulesmanager.Source{Namespace: "ns", Name: "route"}
+	if err := rm.AddRule(spec.URI, spec, source); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEntry(rm, health.NewManager())
+	e.Server.All("/*", e.serve)
+	return e
+}
+
+// send sends req through e.
+func send(e *Entry, req *fasthttp.Request) *fasthttp.Response {
+	ctx := &fasthttp.RequestCtx{}
+	req.CopyTo(&ctx.Request)
+	e.Server.Handler()(ctx)
+
+	res := &fasthttp.Response{}
+	ctx.Response.CopyTo(res)
+	return res
+}
+
+// TestForwardPath checks the path and query k8sservice targets receive for
+// each kind of path rewrite.
+func TestForwardPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RequestURI))
+	}))
+	defer upstream.Close()
+
+	registerK8sService(t)
+
+	tests := []struct {
+		name      string
+		uri       string
+		matchType string
+		rewrite   *v1alpha1.PathRewrite
+		target    string
+		request   string
+		want      string
+	}{
+		{
+			name:    "exact uri keeps path and query",
+			uri:     "a.com/myjsfunc",
+			request: "/myjsfunc?id=3",
+			want:    "/myjsfunc?id=3",
+		},
+		{
+			name:      "pass",
+			uri:       "a.com/myjsfunc",
+			matchType: v1alpha1.MatchPrefix,
+			rewrite:   &v1alpha1.PathRewrite{Type: v1alpha1.RewritePass},
+			request:   "/myjsfunc/users?id=3",
+			want:      "/myjsfunc/users?id=3",
+		},
+		{
+			name:      "pass appends to target path",
+			uri:       "a.com/myjsfunc",
+			matchType: v1alpha1.MatchPrefix,
+			target:    "/base/",
+			request:   "/myjsfunc/users?id=3",
+			want:      "/base/myjsfunc/users?id=3",
+		},
+		{
+			name:      "strip prefix",
+			uri:       "a.com/myjsfunc",
+			matchType: v1alpha1.MatchPrefix,
+			rewrite:   &v1alpha1.PathRewrite{Type: v1alpha1.RewriteStripPrefix},
+			request:   "/myjsfunc/users?id=3",
+			want:      "/users?id=3",
+		},
+		{
+			name:      "strip whole path",
+			uri:       "a.com/myjsfunc",
+			matchType: v1alpha1.MatchPrefix,
+			rewrite:   &v1alpha1.PathRewrite{Type: v1alpha1.RewriteStripPrefix},
+			request:   "/myjsfunc?id=3",
+			want:      "/?id=3",
+		},
+		{
+			name:      "strip prefix with parameters",
+			uri:       "a.com/users/:id/orders",
+			matchType: v1alpha1.MatchPrefix,
+			rewrite:   &v1alpha1.PathRewrite{Type: v1alpha1.RewriteStripPrefix},
+			request:   "/users/7/orders/9",
+			want:      "/9",
+		},
+		{
+			name:      "strip prefix keeps escapes",
+			uri:       "a.com/myjsfunc",
+			matchType: v1alpha1.MatchPrefix,
+			rewrite:   &v1alpha1.PathRewrite{Type: v1alpha1.RewriteStripPrefix},
+			request:   "/myjsfunc/a%2Fb?q=a%20b",
+			want:      "/a%2Fb?q=a%20b",
+		},
+		{
+			name:      "replace prefix",
+			uri:       "a.com/myjsfunc",
+			matchType: v1alpha1.MatchPrefix,
+			rewrite:   &v1alpha1.PathRewrite{Type: v1alpha1.RewriteReplacePrefix, Prefix: "/v2"},
+			request:   "/myjsfunc/users?id=3",
+			want:      "/v2/users?id=3",
+		},
+		{
+			name:    "replace exact path",
+			uri:     "a.com/myjsfunc",
+			rewrite: &v1alpha1.PathRewrite{Type: v1alpha1.RewriteReplacePrefix, Prefix: "/v2/"},
+			request: "/myjsfunc",
+			want:    "/v2",
+		},
+		{
+			name:      "regex",
+			uri:       "a.com/api",
+			matchType: v1alpha1.MatchPrefix,
+			rewrite: &v1alpha1.PathRewrite{
+				Type:        v1alpha1.RewriteRegex,
+				Regex:       `^/api/(\w+)/(.*)$`,
+				Replacement: "/$2/$1",
+			},
+			request: "/api/users/3?x=y",
+			want:    "/3/users?x=y",
+		},
+		{
+			name:      "regex without a leading slash",
+			uri:       `a\.com/api/.*`,
+			matchType: v1alpha1.MatchRegex,
+			rewrite: &v1alpha1.PathRewrite{
+				Type:        v1alpha1.RewriteRegex,
+				Regex:       `^/api/`,
+				Replacement: "",
+			},
+			request: "/api/users",
+			want:    "/users",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := v1alpha1.RouteSpec{
+				URI:       tt.uri,
+				MatchType: tt.matchType,
+				Rewrite:   tt.rewrite,
+				Targets: []v1alpha1.RouteTarget{{
+					Target: upstream.URL + tt.target,
+					Type:   backend.K8sServiceBackendType,
+					Ratio:  1,
+				}},
+			}
+			res := serveOnce(t, spec, tt.request, nil)
+			if status := res.StatusCode(); status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, res.Body())
+			}
+			if got := string(res.Body()); got != tt.want {
+				t.Errorf("upstream got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestForwardHeaders checks the headers targets receive and the response
+// headers clients get, including over a retry.
+func TestForwardHeaders(t *testing.T) {
+	tries := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto",
+			"Forwarded", "X-Route", "X-Tag", "X-Secret"} {
+			w.Header()[name] = r.Header[name]
+		}
+		w.Header().Set("Server", "upstream")
+		if tries == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+	registerK8sService(t)
+
+	spec := v1alpha1.RouteSpec{
+		URI:     "a.com/fn",
+		Targets: []v1alpha1.RouteTarget{{Target: upstream.URL, Type: backend.K8sServiceBackendType, Ratio: 1}},
+		Retry:   &v1alpha1.RetryPolicy{Attempts: 2, RetryOn: []string{v1alpha1.RetryOn5xx}},
+		Headers: &v1alpha1.Headers{
+			Request: &v1alpha1.HeaderRules{
+				Remove: []string{"X-Secret"},
+				Set:    []v1alpha1.HeaderValue{{Name: "X-Route", Value: "${route} via ${backend}"}},
+				Add:    []v1alpha1.HeaderValue{{Name: "X-Tag", Value: "edge"}},
+			},
+			Response: &v1alpha1.HeaderRules{
+				Remove: []string{"Server"},
+				Add:    []v1alpha1.HeaderValue{{Name: "X-Served-By", Value: "${uri}"}},
+			},
+		},
+	}
+	res := serveOnce(t, spec, "/fn", map[string]string{
+		"X-Forwarded-For":   "10.0.0.1",
+		"X-Forwarded-Host":  "evil.com",
+		"X-Forwarded-Proto": "https",
+		"X-Secret":          "s3cr3t",
+	})
+	if status := res.StatusCode(); status != http.StatusOK {
+		t.Fatalf("status %d after %d tries: %s", status, tries, res.Body())
+	}
+
+	want := map[string][]string{
+		"X-Forwarded-For":   {"10.0.0.1, 0.0.0.0"},
+		"X-Forwarded-Host":  {"a.com"},
+		"X-Forwarded-Proto": {"http"},
+		"Forwarded":         {`for=0.0.0.0;host="a.com";proto=http`},
+		"X-Route":           {"ns/route via k8sservice"},
+		"X-Tag":             {"edge"},
+		"X-Secret":          nil,
+		"Server":            nil,
+		"X-Served-By":       {"a.com/fn"},
+	}
+	for name, values := range want {
+		var got []string
+		res.Header.VisitAll(func(key, value []byte) {
+			if string(key) == name {
+				got = append(got, string(value))
+			}
+		})
+		if !reflect.DeepEqual(got, values) {
+			t.Errorf("%s: got %q, want %q", name, got, values)
+		}
+	}
+}
+
+// TestSessionAffinity checks that requests of one user keep their target,
+// by the hash of their key and by the affinity cookie.
+func TestSessionAffinity(t *testing.T) {
+	registerK8sService(t)
+	var targets []v1alpha1.RouteTarget
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer upstream.Close()
+		targets = append(targets, v1alpha1.RouteTarget{
+			Target: upstream.URL, Type: backend.K8sServiceBackendType, Ratio: 1,
+		})
+	}
+
+	spec := v1alpha1.RouteSpec{
+		URI:     "a.com/fn",
+		Targets: targets,
+		SessionAffinity: &v1alpha1.SessionAffinity{
+			Key:    v1alpha1.AffinityByHeader,
+			Name:   "X-User",
+			Cookie: &v1alpha1.AffinityCookie{Name: "edge-affinity"},
+		},
+	}
+	picked := map[string]bool{}
+	for _, user := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+		res := serveOnce(t, spec, "/fn", map[string]string{"X-User": user})
+		first := string(res.Body())
+		picked[first] = true
+		cookie := &fasthttp.Cookie{}
+		cookie.SetKey("edge-affinity")
+		if !res.Header.Cookie(cookie) {
+			t.Fatalf("%s: no affinity cookie", user)
+		}
+		for i := 0; i < 5; i++ {
+			if got := string(serveOnce(t, spec, "/fn", map[string]string{"X-User": user}).Body()); got != first {
+				t.Errorf("%s: got target %s, then %s", user, first, got)
+			}
+		}
+
+		// the cookie wins over the key, and is not issued again
+		res = serveOnce(t, spec, "/fn", map[string]string{
+			"X-User": "someone-else",
+			"Cookie": "edge-affinity=" + string(cookie.Value()),
+		})
+		if got := string(res.Body()); got != first {
+			t.Errorf("%s: cookie picked target %s, want %s", user, got, first)
+		}
+		if res.Header.Peek(fasthttp.HeaderSetCookie) != nil {
+			t.Errorf("%s: affinity cookie issued again", user)
+		}
+	}
+	if len(picked) < 2 {
+		t.Errorf("all users got target %v", picked)
+	}
+}
+
+// TestForwardRetryBody checks that a request retried after a timed out try
+// is sent with its body again.
+func TestForwardRetryBody(t *testing.T) {
+	var tries int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&tries, 1) == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte("body=" + string(body)))
+	}))
+	defer upstream.Close()
+	registerK8sService(t)
+
+	spec := v1alpha1.RouteSpec{
+		URI:     "a.com/fn",
+		Targets: []v1alpha1.RouteTarget{{Target: upstream.URL, Type: backend.K8sServiceBackendType, Ratio: 1}},
+		Retry: &v1alpha1.RetryPolicy{
+			Attempts:      2,
+			RetryOn:       []string{v1alpha1.RetryOnTimeout},
+			PerTryTimeout: &metav1.Duration{Duration: 100 * time.Millisecond},
+		},
+	}
+	req := &fasthttp.Request{}
+	req.SetRequestURI("/fn")
+	req.Header.SetHost("a.com")
+	req.Header.SetMethod(http.MethodPut)
+	req.SetBodyString("hello")
+
+	res := serveRequest(t, spec, req)
+	if status := res.StatusCode(); status != http.StatusOK {
+		t.Fatalf("status %d after %d tries: %s", status, atomic.LoadInt32(&tries), res.Body())
+	}
+	if got := string(res.Body()); got != "body=hello" {
+		t.Errorf("upstream got %q on the retry, want %q", got, "body=hello")
+	}
+}
+
+// TestForwardIdleTimeout checks that the idle timeout bounds the gaps
+// between reads from the target, not the whole call.
+func TestForwardIdleTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gap, _ := time.ParseDuration(r.Header.Get("X-Gap"))
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 4; i++ {
+			w.(http.Flusher).Flush()
+			time.Sleep(gap)
+			w.Write([]byte("."))
+		}
+	}))
+	defer upstream.Close()
+	registerK8sService(t)
+
+	spec := v1alpha1.RouteSpec{
+		URI:         "a.com/fn",
+		Targets:     []v1alpha1.RouteTarget{{Target: upstream.URL, Type: backend.K8sServiceBackendType, Ratio: 1}},
+		IdleTimeout: &metav1.Duration{Duration: 200 * time.Millisecond},
+	}
+	tests := []struct {
+		name string
+		gap  string
+		want int
+	}{
+		{name: "steady", gap: "50ms", want: http.StatusOK},
+		{name: "stalled", gap: "400ms", want: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		res := serveOnce(t, spec, "/fn", map[string]string{"X-Gap": tt.gap})
+		if status := res.StatusCode(); status != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, status, tt.want, res.Body())
+		}
+	}
+}
+
+// TestAccessLogRequest checks that the access log records the request as
+// received, not as sent to the target.
+func TestAccessLogRequest(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Slow") != "" {
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+	registerK8sService(t)
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{name: "forwarded"},
+		{name: "timed out", header: "X-Slow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			logger, err := accesslog.New(accesslog.Config{Sink: "file:" + path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := newServingEntry(t, v1alpha1.RouteSpec{
+				URI:         "a.com/fn",
+				Targets:     []v1alpha1.RouteTarget{{Target: upstream.URL, Type: backend.K8sServiceBackendType, Ratio: 1}},
+				IdleTimeout: &metav1.Duration{Duration: 100 * time.Millisecond},
+			})
+			e.AccessLog = logger
+
+			req := &fasthttp.Request{}
+			req.SetRequestURI("/fn?id=3")
+			req.Header.SetHost("a.com")
+			req.Header.SetMethod(http.MethodPut)
+			if tt.header != "" {
+				req.Header.Set(tt.header, "1")
+			}
+			req.SetBodyString("hello")
+			send(e, req)
+			if err := logger.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got accesslog.Record
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("%v: %s", err, data)
+			}
+			if got.Host != "a.com" || got.Path != "/fn?id=3" || got.Method != http.MethodPut || got.BytesIn != 5 {
+				t.Errorf("got host %q, path %q, method %s, %d bytes in, want a.com, /fn?id=3, PUT, 5 bytes in",
+					got.Host, got.Path, got.Method, got.BytesIn)
+			}
+		})
+	}
+}
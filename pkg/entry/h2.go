@@ -0,0 +1,165 @@
+package entry
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+)
+
+// handshakeTimeout bounds the TLS handshake of HTTPS connections.
+const handshakeTimeout = 10 * time.Second
+
+// h2Listener completes the TLS handshake of the connections it accepts,
+// serves those that negotiated h2 with serve, and hands the others over to
+// fasthttp, which only speaks HTTP/1.1.
+type h2Listener struct {
+	net.Listener
+	serve func(conn net.Conn)
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newH2Listener(ln net.Listener, serve func(conn net.Conn)) *h2Listener {
+	h := &h2Listener{
+		Listener: ln,
+		serve:    serve,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		closed:   make(chan struct{}),
+	}
+	go h.accept()
+	return h
+}
+
+// accept accepts connections until the listener fails, a slow handshake
+// does not hold up the others.
+func (h *h2Listener) accept() {
+	for {
+		conn, err := h.Listener.Accept()
+		if err != nil {
+			select {
+			case h.errs <- err:
+			case <-h.closed:
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		go h.handshake(conn)
+	}
+}
+
+func (h *h2Listener) handshake(conn net.Conn) {
+	tlsConn, ok := conn.(interface {
+		Handshake() error
+		ConnectionState() tls.ConnectionState
+	})
+	if !ok {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	if tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		h.serve(conn)
+		return
+	}
+	select {
+	case h.conns <- conn:
+	case <-h.closed:
+		conn.Close()
+	}
+}
+
+func (h *h2Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-h.conns:
+		return conn, nil
+	case err := <-h.errs:
+		return nil, err
+	}
+}
+
+func (h *h2Listener) Close() error {
+	h.closeOnce.Do(func() { close(h.closed) })
+	return h.Listener.Close()
+}
+
+// serveH2 serves conn, which negotiated h2, until it is closed or the entry
+// shuts down.
+func (e *Entry) serveH2(conn net.Conn) {
+	e.h2Server.ServeConn(conn, &http2.ServeConnOpts{
+		BaseConfig: e.h2Base,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			e.serveH2Request(conn, w, r)
+		}),
+	})
+}
+
+// h2ConnHeaders are the connection specific headers HTTP/2 forbids in
+// responses.
+var h2ConnHeaders = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// serveH2Request passes r, received on conn, to the fiber handlers of the
+// entry and writes their response to w. conn keeps the listener name and
+// TLS state of the request visible to them.
+func (e *Entry) serveH2Request(conn net.Conn, w http.ResponseWriter, r *http.Request) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init2(conn, nil, false)
+
+	req := &ctx.Request
+	req.Header.SetMethod(r.Method)
+	req.SetRequestURI(r.RequestURI)
+	for name, values := range r.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.SetHost(r.Host)
+
+	limit := int64(e.Server.Config().BodyLimit)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	switch {
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	case int64(len(body)) > limit:
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	req.SetBody(body)
+
+	e.Server.Handler()(ctx)
+
+	res := &ctx.Response
+	res.Header.VisitAll(func(name, value []byte) {
+		if !h2ConnHeaders[string(name)] {
+			w.Header().Add(string(name), string(value))
+		}
+	})
+	w.WriteHeader(res.StatusCode())
+	res.BodyWriteTo(w)
+}
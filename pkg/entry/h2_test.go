@@ -0,0 +1,102 @@
+package entry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+	"github.com/seveirbian/edgeserverless/pkg/certs"
+)
+
+// newCert returns a self-signed certificate valid for names.
+func newCert(t *testing.T, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestHTTPSProtocols checks that the HTTPS listener serves both h2 and
+// HTTP/1.1 clients, as negotiated by ALPN.
+func TestHTTPSProtocols(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.URL.RequestURI() + " " + string(body)))
+	}))
+	defer upstream.Close()
+	registerK8sService(t)
+
+	e := newServingEntry(t, v1alpha1.RouteSpec{
+		URI:       "a.com/fn",
+		Targets:   []v1alpha1.RouteTarget{{Target: upstream.URL, Type: backend.K8sServiceBackendType, Ratio: 1}},
+		Listeners: []string{"public-tls"},
+	})
+	e.Certs = certs.NewStore()
+	if err := e.Certs.Set("a.com", newCert(t, "a.com")); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "tls.sock")
+	e.Listeners = []Listener{{Name: "public-tls", Kind: ListenerHTTPS, Addr: "unix:" + sock}}
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Shutdown(0, time.Second)
+
+	dial := func(network, addr string, config *tls.Config) (net.Conn, error) {
+		return tls.Dial("unix", sock, config)
+	}
+	config := &tls.Config{InsecureSkipVerify: true, ServerName: "a.com"}
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		want      string
+	}{
+		{name: "h2", transport: &http2.Transport{DialTLS: dial, TLSClientConfig: config}, want: "HTTP/2.0"},
+		{name: "http/1.1", transport: &http.Transport{
+			DialTLS: func(network, addr string) (net.Conn, error) {
+				return dial(network, addr, config)
+			},
+		}, want: "HTTP/1.1"},
+	}
+	for _, tt := range tests {
+		client := &http.Client{Transport: tt.transport}
+		res, err := client.Post("https://a.com/fn?id=3", "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || res.Proto != tt.want || string(body) != "/fn?id=3 hello" {
+			t.Errorf("%s: got %s %d %q, want %s 200 %q", tt.name, res.Proto, res.StatusCode, body, tt.want, "/fn?id=3 hello")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package entry
+
+import (
+	"net"
+	"strings"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+)
+
+// Headers added to every request sent to a target.
+const (
+	ForwardedHeader       = "Forwarded"
+	XForwardedForHeader   = "X-Forwarded-For"
+	XForwardedHostHeader  = "X-Forwarded-Host"
+	XForwardedProtoHeader = "X-Forwarded-Proto"
+)
+
+// headerVars are the values of the variables of route header rules, see
+// rulesmanager.HeaderVariables.
+type headerVars struct {
+	clientIP  string
+	host      string
+	method    string
+	path      string
+	scheme    string
+	requestID string
+	route     string
+	uri       string
+	target    v1alpha1.RouteTarget
+}
+
+func newHeaderVars(c *fiber.Ctx, match *rulesmanager.Match) *headerVars {
+	return &headerVars{
+		clientIP:  c.IP(),
+		host:      string(c.Request().Host()),
+		method:    c.Method(),
+		path:      c.Path(),
+		scheme:    scheme(c),
+		requestID: requestID(c),
+		route:     match.Source.Namespace + "/" + match.Source.Name,
+		uri:       match.URI,
+	}
+}
+
+func (v *headerVars) get(name string) string {
+	switch name {
+	case "client_ip":
+		return v.clientIP
+	case "host":
+		return v.host
+	case "method":
+		return v.method
+	case "path":
+		return v.path
+	case "scheme":
+		return v.scheme
+	case "request_id":
+		return v.requestID
+	case "route":
+		return v.route
+	case "uri":
+		return v.uri
+	case "target":
+		return v.target.Target
+	case "backend":
+		return v.target.Type
+	}
+	return ""
+}
+
+// setForwarded appends the client to X-Forwarded-For and Forwarded and sets
+// X-Forwarded-Host and X-Forwarded-Proto to the host and scheme the entry
+// received. Values sent by the client are replaced, they can not be trusted.
+func setForwarded(req *fasthttp.Request, vars *headerVars) {
+	appendHeader(req, XForwardedForHeader, vars.clientIP)
+	req.Header.Set(XForwardedHostHeader, vars.host)
+	req.Header.Set(XForwardedProtoHeader, vars.scheme)
+
+	node := vars.clientIP
+	if ip := net.ParseIP(node); ip != nil && ip.To4() == nil {
+		node = `"[` + node + `]"`
+	}
+	appendHeader(req, ForwardedHeader,
+		"for="+node+";host="+quote(vars.host)+";proto="+vars.scheme)
+}
+
+// appendHeader appends value to the comma separated list in header name.
+func appendHeader(req *fasthttp.Request, name string, value string) {
+	if prior := req.Header.Peek(name); len(prior) > 0 {
+		value = string(prior) + ", " + value
+	}
+	req.Header.Set(name, value)
+}
+
+// quote returns value as a quoted string of a Forwarded parameter.
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// scheme returns the scheme the request was received with. Unlike
+// Ctx.Protocol it ignores X-Forwarded-Proto sent by the client.
+func scheme(c *fiber.Ctx) string {
+	if c.Context().IsTLS() {
+		return "https"
+	}
+	return "http"
+}
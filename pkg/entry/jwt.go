@@ -0,0 +1,140 @@
+package entry
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// JWTVerifier checks the signature of the bearer tokens whose claims key
+// rate limits, with an RSA public key for the RS algorithms or an HMAC
+// secret for the HS ones.
+type JWTVerifier struct {
+	rsaKey *rsa.PublicKey
+	secret []byte
+}
+
+// LoadJWTVerifier reads the key of a JWTVerifier from path: a PEM RSA public
+// key, any other content being an HMAC secret.
+func LoadJWTVerifier(path string) (*JWTVerifier, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[jwt] read %s error: %v", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("[jwt] %s is empty", path)
+		}
+		return &JWTVerifier{secret: secret}, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("[jwt] parse %s error: %v", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("[jwt] %s is not an RSA public key", path)
+	}
+	return &JWTVerifier{rsaKey: rsaKey}, nil
+}
+
+// Claims verifies token and returns its claims. Tokens past their exp or
+// before their nbf are refused.
+func (v *JWTVerifier) Claims(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("not a signed jwt")
+	}
+	header, err := decodeJWTPart(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	alg, _ := header["alg"].(string)
+	if err := v.verify(alg, parts[0]+"."+parts[1], parts[2]); err != nil {
+		return nil, err
+	}
+
+	claims, err := decodeJWTPart(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if exp, ok := claims["exp"].(json.Number); ok {
+		if t, err := exp.Int64(); err != nil || !now.Before(time.Unix(t, 0)) {
+			return nil, errors.New("jwt expired")
+		}
+	}
+	if nbf, ok := claims["nbf"].(json.Number); ok {
+		if t, err := nbf.Int64(); err != nil || now.Before(time.Unix(t, 0)) {
+			return nil, errors.New("jwt not valid yet")
+		}
+	}
+	return claims, nil
+}
+
+// verify checks signature, the encoded signature of signed, against alg,
+// which must suit the key of v.
+func (v *JWTVerifier) verify(alg string, signed string, signature string) error {
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("bad jwt signature: %v", err)
+	}
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported jwt alg %q", alg)
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported jwt alg %q", alg)
+	}
+	switch {
+	case alg[:2] == "HS" && v.secret != nil:
+		mac := hmac.New(hash.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errors.New("bad jwt signature")
+		}
+		return nil
+	case alg[:2] == "RS" && v.rsaKey != nil:
+		h := hash.New()
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(v.rsaKey, hash, h.Sum(nil), sig); err != nil {
+			return errors.New("bad jwt signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("jwt alg %q does not match the key", alg)
+}
+
+func decodeJWTPart(part string) (map[string]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return nil, fmt.Errorf("bad jwt encoding: %v", err)
+	}
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("bad jwt json: %v", err)
+	}
+	return fields, nil
+}
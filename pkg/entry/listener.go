@@ -0,0 +1,175 @@
+package entry
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Kinds of listeners.
+const (
+	// ListenerHTTP serves routes over plain HTTP.
+	ListenerHTTP = "http"
+	// ListenerHTTPS serves routes over TLS with the certificates of
+	// Entry.Certs, HTTP/1.1 only.
+	ListenerHTTPS = "https"
+	// ListenerAdmin serves Entry.Admin and no routes.
+	ListenerAdmin = "admin"
+)
+
+// Listener is an address the entry accepts connections on. Routes can be
+// bound to listeners by name with RouteSpec.Listeners.
+type Listener struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Addr is a host:port, or unix:<path> for a unix socket.
+	Addr string `json:"addr"`
+}
+
+// ListenerFile is the content of the file passed to route-proxy
+// -listenerConfig.
+type ListenerFile struct {
+	Listeners []Listener `json:"listeners"`
+}
+
+// ParseListener parses a listener given as [name=]kind://addr, like
+// "http://:1122" or "internal=http://unix:/run/route-proxy.sock". The name
+// defaults to the kind.
+func ParseListener(s string) (Listener, error) {
+	var l Listener
+	if i := strings.Index(s, "="); i >= 0 && i < strings.Index(s, "://") {
+		l.Name, s = s[:i], s[i+1:]
+	}
+
+	i := strings.Index(s, "://")
+	if i < 0 {
+		return Listener{}, fmt.Errorf("listener %q is not [name=]kind://addr", s)
+	}
+	l.Kind, l.Addr = s[:i], s[i+3:]
+	if l.Name == "" {
+		l.Name = l.Kind
+	}
+
+	return l, ValidateListeners([]Listener{l})
+}
+
+// LoadListenerFile reads listeners from a YAML or JSON file.
+func LoadListenerFile(path string) ([]Listener, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &ListenerFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, fmt.Errorf("[entry] parse %s error: %v", path, err)
+	}
+	if err := ValidateListeners(file.Listeners); err != nil {
+		return nil, fmt.Errorf("[entry] %s: %v", path, err)
+	}
+
+	return file.Listeners, nil
+}
+
+// ValidateListeners checks kinds, addresses and that names are unique.
+func ValidateListeners(listeners []Listener) error {
+	names := map[string]bool{}
+	for _, l := range listeners {
+		if l.Name == "" {
+			return fmt.Errorf("listener %s has no name", l.Addr)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("listener %s defined twice", l.Name)
+		}
+		names[l.Name] = true
+
+		switch l.Kind {
+		case ListenerHTTP, ListenerHTTPS, ListenerAdmin:
+		default:
+			return fmt.Errorf("listener %s has unknown kind %q, known: http, https, admin", l.Name, l.Kind)
+		}
+		if l.Addr == "" || l.Addr == "unix:" {
+			return fmt.Errorf("listener %s has no address", l.Name)
+		}
+	}
+	return nil
+}
+
+// listen opens the socket of l. A unix socket left over by a previous run is
+// removed first.
+func listen(l Listener) (net.Listener, error) {
+	if strings.HasPrefix(l.Addr, "unix:") {
+		path := strings.TrimPrefix(l.Addr, "unix:")
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", l.Addr)
+}
+
+// namedListener tags the connections it accepts with the name of their
+// listener, so requests can be matched against the listeners of a route.
+type namedListener struct {
+	net.Listener
+	name string
+}
+
+func (ln *namedListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// keep TLS connections a *tls.Conn underneath, fasthttp detects TLS
+	// through its methods
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return &namedTLSConn{Conn: tlsConn, name: ln.name}, nil
+	}
+	return &namedConn{Conn: conn, name: ln.name}, nil
+}
+
+type namedConn struct {
+	net.Conn
+	name string
+}
+
+func (c *namedConn) ListenerName() string {
+	return c.name
+}
+
+type namedTLSConn struct {
+	*tls.Conn
+	name string
+}
+
+func (c *namedTLSConn) ListenerName() string {
+	return c.name
+}
+
+// listenerName returns the name of the listener the request came in on.
+func listenerName(c *fiber.Ctx) string {
+	if named, ok := c.Context().Conn().(interface{ ListenerName() string }); ok {
+		return named.ListenerName()
+	}
+	return ""
+}
+
+// servedOn reports whether a route bound to listeners is served on the
+// listener named name. Routes bound to no listener are served on all.
+func servedOn(listeners []string, name string) bool {
+	if len(listeners) == 0 {
+		return true
+	}
+	for _, l := range listeners {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package entry
+
+import (
+	"strconv"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+
+	"github.com/seveirbian/edgeserverless/pkg/accesslog"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+	"github.com/seveirbian/edgeserverless/pkg/metrics"
+)
+
+const errorCodeKey = "errorcode"
+
+// observation collects the labels of one request for metrics and the
+// access log.
+type observation struct {
+	start     time.Time
+	accessLog *accesslog.Logger
+
+	// request holds the fields of the access log record taken from the
+	// request as received, backends and header rules change the request and
+	// calls move its body out
+	request   accesslog.Record
+	namespace string
+	route     string
+	target    string
+	backend   string
+
+	attempts int
+	upstream time.Duration
+	err      error
+}
+
+// newObservation starts observing the request of c. Strings taken from the
+// request are copied, fasthttp reuses their buffers once the handler returns
+// and the record is written asynchronously.
+func newObservation(accessLog *accesslog.Logger, c *fiber.Ctx) *observation {
+	o := &observation{start: time.Now(), accessLog: accessLog}
+	if accessLog != nil {
+		req := c.Request()
+		o.request = accesslog.Record{
+			Method:    string(req.Header.Method()),
+			Host:      string(req.Host()),
+			Path:      string(req.RequestURI()),
+			Protocol:  string(req.Header.Protocol()),
+			BytesIn:   len(req.Body()),
+			Referer:   string(req.Header.Referer()),
+			UserAgent: string(req.Header.UserAgent()),
+		}
+	}
+	return o
+}
+
+func (o *observation) done(c *fiber.Ctx) {
+	code := strconv.Itoa(c.Response().StatusCode())
+	metrics.Requests.WithLabelValues(o.namespace, o.route, o.target, o.backend, code).Inc()
+	metrics.RequestDuration.WithLabelValues(o.namespace, o.route, o.target, o.backend, code).
+		Observe(time.Since(o.start).Seconds())
+
+	if errCode, ok := c.Locals(errorCodeKey).(string); ok {
+		metrics.RequestErrors.WithLabelValues(o.namespace, o.route, o.target, o.backend, errCode).Inc()
+	}
+
+	if o.accessLog != nil {
+		o.accessLog.Log(o.record(c))
+	}
+}
+
+// record builds the access log record of the request from the fields taken
+// when it was received and the outcome of serving it.
+func (o *observation) record(c *fiber.Ctx) *accesslog.Record {
+	res := c.Response()
+
+	r := o.request
+	r.Time = o.start
+	r.RequestID = requestID(c)
+	r.ClientIP = c.IP()
+	r.Status = res.StatusCode()
+	r.BytesOut = len(res.Body())
+	r.Target = o.target
+	r.Backend = o.backend
+	r.Attempts = o.attempts
+	r.Duration = time.Since(o.start)
+	r.UpstreamLatency = o.upstream
+	if o.route != "" {
+		r.Route = o.namespace + "/" + o.route
+	}
+	if o.err != nil {
+		r.Error = o.err.Error()
+	}
+	return &r
+}
+
+// invoke calls bke, records backend metrics under the backend name of o and
+// adds the call to its upstream latency.
+func (o *observation) invoke(bke backend.Backend, target string,
+	req *fasthttp.Request, res *fasthttp.Response, timeout time.Duration, idleTimeout time.Duration) error {
+	name := o.backend
+	inflight := metrics.BackendInflight.WithLabelValues(name)
+	inflight.Inc()
+	defer inflight.Dec()
+
+	start := time.Now()
+	err := bke.Invoke(target, req, res, timeout, idleTimeout)
+	o.attempts++
+	o.upstream += time.Since(start)
+	o.err = err
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	metrics.BackendDuration.WithLabelValues(name, result).Observe(time.Since(start).Seconds())
+
+	return err
+}
@@ -0,0 +1,74 @@
+package entry
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/ratelimit"
+)
+
+// Headers describing the rate limit of a route on its responses.
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// rateLimitKey returns the key of the bucket of the request in c.
+func (e *Entry) rateLimitKey(c *fiber.Ctx, rl *v1alpha1.RateLimit) string {
+	switch rl.Key {
+	case v1alpha1.RateLimitByClientIP:
+		return c.IP()
+	case v1alpha1.RateLimitByHeader:
+		return c.Get(rl.Name)
+	case v1alpha1.RateLimitByJWTClaim:
+		return jwtClaim(e.JWTVerifier, c.Get(fiber.HeaderAuthorization), rl.Name)
+	}
+	return ""
+}
+
+// jwtClaim returns claim of the bearer token in authorization, "" when there
+// is none or verifier does not accept the token. Claims are not trusted
+// without a verifier, so "" is returned too.
+func jwtClaim(verifier *JWTVerifier, authorization string, claim string) string {
+	const prefix = "bearer "
+	if verifier == nil {
+		return ""
+	}
+	if len(authorization) < len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return ""
+	}
+	claims, err := verifier.Claims(strings.TrimSpace(authorization[len(prefix):]), time.Now())
+	if err != nil {
+		return ""
+	}
+	switch v := claims[claim].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// setRateLimitHeaders describes result on the response, with Retry-After
+// when the request was limited.
+func setRateLimitHeaders(c *fiber.Ctx, result ratelimit.Result) {
+	c.Set(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+	c.Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+	c.Set(RateLimitResetHeader, strconv.FormatInt(seconds(result.Reset), 10))
+	if !result.Allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(seconds(result.RetryAfter), 10))
+	}
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,103 @@
+package entry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+)
+
+// TestRateLimit checks the requests limited per header value and the rate
+// limit headers of the responses.
+func TestRateLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	registerK8sService(t)
+
+	e := newServingEntry(t, v1alpha1.RouteSpec{
+		URI:       "a.com/fn",
+		Targets:   []v1alpha1.RouteTarget{{Target: upstream.URL, Type: backend.K8sServiceBackendType, Ratio: 1}},
+		RateLimit: &v1alpha1.RateLimit{RequestsPerSecond: 1, Burst: 2, Key: v1alpha1.RateLimitByHeader, Name: "X-User"},
+	})
+
+	tests := []struct {
+		user       string
+		status     int
+		remaining  string
+		retryAfter string
+	}{
+		{user: "u1", status: http.StatusOK, remaining: "1"},
+		{user: "u1", status: http.StatusOK, remaining: "0"},
+		{user: "u1", status: http.StatusTooManyRequests, remaining: "0", retryAfter: "1"},
+		{user: "u2", status: http.StatusOK, remaining: "1"},
+		{status: http.StatusOK, remaining: "1"},
+	}
+	for i, tt := range tests {
+		req := &fasthttp.Request{}
+		req.SetRequestURI("/fn")
+		req.Header.SetHost("a.com")
+		if tt.user != "" {
+			req.Header.Set("X-User", tt.user)
+		}
+		res := send(e, req)
+
+		got := []string{
+			string(res.Header.Peek(RateLimitLimitHeader)),
+			string(res.Header.Peek(RateLimitRemainingHeader)),
+			string(res.Header.Peek(fiber.HeaderRetryAfter)),
+		}
+		if res.StatusCode() != tt.status || got[0] != "2" || got[1] != tt.remaining || got[2] != tt.retryAfter {
+			t.Errorf("request %d of %q: got status %d, limit, remaining and retry after %q, want %d, 2, %s and %q",
+				i, tt.user, res.StatusCode(), got, tt.status, tt.remaining, tt.retryAfter)
+		}
+	}
+}
+
+// signJWT returns a token of claims, a JSON object, signed with secret.
+func signJWT(alg string, claims string, secret string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	signed := encode([]byte(`{"alg":"`+alg+`","typ":"JWT"}`)) + "." + encode([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + encode(mac.Sum(nil))
+}
+
+// TestJWTClaim checks that only the claims of tokens verified by the
+// verifier key rate limits.
+func TestJWTClaim(t *testing.T) {
+	verifier := &JWTVerifier{secret: []byte("secret")}
+	expired := time.Now().Add(-time.Minute).Unix()
+	signed := signJWT("none", `{"sub":"u1"}`, "")
+	unsigned := signed[:strings.LastIndex(signed, ".")+1]
+
+	tests := []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+		want     string
+	}{
+		{name: "verified", verifier: verifier, token: signJWT("HS256", `{"sub":"u1"}`, "secret"), want: "u1"},
+		{name: "number claim", verifier: verifier, token: signJWT("HS256", `{"sub":12}`, "secret"), want: "12"},
+		{name: "no verifier", token: signJWT("HS256", `{"sub":"u1"}`, "secret")},
+		{name: "other secret", verifier: verifier, token: signJWT("HS256", `{"sub":"u1"}`, "forged")},
+		{name: "other alg", verifier: verifier, token: signJWT("RS256", `{"sub":"u1"}`, "secret")},
+		{name: "unsigned", verifier: verifier, token: unsigned},
+		{name: "expired", verifier: verifier, token: signJWT("HS256", fmt.Sprintf(`{"sub":"u1","exp":%d}`, expired), "secret")},
+	}
+	for _, tt := range tests {
+		if got := jwtClaim(tt.verifier, "Bearer "+tt.token, "sub"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package entry
+
+import fiber "github.com/gofiber/fiber/v2"
+
+// matchRequest exposes a fiber request to rulesmanager match predicates.
+type matchRequest struct {
+	c *fiber.Ctx
+}
+
+func (r matchRequest) Method() string {
+	return r.c.Method()
+}
+
+func (r matchRequest) Header(name string) (string, bool) {
+	v := r.c.Request().Header.Peek(name)
+	return string(v), v != nil
+}
+
+func (r matchRequest) Query(name string) (string, bool) {
+	args := r.c.Request().URI().QueryArgs()
+	if !args.Has(name) {
+		return "", false
+	}
+	return string(args.Peek(name)), true
+}
+
+func (r matchRequest) Cookie(name string) (string, bool) {
+	v := r.c.Request().Header.Cookie(name)
+	return string(v), v != nil
+}
@@ -0,0 +1,115 @@
+package entry
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+var idempotentMethods = map[string]bool{
+	fiber.MethodGet:     true,
+	fiber.MethodHead:    true,
+	fiber.MethodOptions: true,
+	fiber.MethodTrace:   true,
+	fiber.MethodPut:     true,
+	fiber.MethodDelete:  true,
+}
+
+// retryPolicy is a RetryPolicy resolved for one request.
+type retryPolicy struct {
+	attempts      int
+	retryOn       map[string]bool
+	perTryTimeout time.Duration
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	idempotent    bool
+}
+
+func newRetryPolicy(policy *v1alpha1.RetryPolicy, method string) *retryPolicy {
+	p := &retryPolicy{attempts: 1}
+	if policy == nil {
+		return p
+	}
+
+	if policy.Attempts > 1 {
+		p.attempts = int(policy.Attempts)
+	}
+	p.retryOn = map[string]bool{}
+	for _, on := range policy.RetryOn {
+		p.retryOn[on] = true
+	}
+	if len(p.retryOn) == 0 {
+		p.retryOn[v1alpha1.RetryOnConnectFailure] = true
+	}
+	if policy.PerTryTimeout != nil {
+		p.perTryTimeout = policy.PerTryTimeout.Duration
+	}
+	if policy.Backoff != nil {
+		p.backoff = policy.Backoff.Duration
+	}
+	if policy.MaxBackoff != nil {
+		p.maxBackoff = policy.MaxBackoff.Duration
+	}
+	p.idempotent = policy.RetryNonIdempotent || idempotentMethods[method]
+
+	return p
+}
+
+// shouldRetry reports whether a try that ended with err and status may be
+// retried. Only connect failures are retried for non idempotent requests,
+// since the target never saw them.
+func (p *retryPolicy) shouldRetry(err error, status int) bool {
+	if err != nil {
+		if isConnectFailure(err) {
+			return p.retryOn[v1alpha1.RetryOnConnectFailure]
+		}
+		if !p.idempotent {
+			return false
+		}
+		return isTimeout(err) && p.retryOn[v1alpha1.RetryOnTimeout]
+	}
+
+	if !p.idempotent {
+		return false
+	}
+	switch {
+	case status == fiber.StatusTooManyRequests:
+		return p.retryOn[v1alpha1.RetryOn429]
+	case status >= 500:
+		return p.retryOn[v1alpha1.RetryOn5xx]
+	}
+	return false
+}
+
+// delay returns how long to wait before the retry following try n.
+func (p *retryPolicy) delay(n int) time.Duration {
+	d := p.backoff
+	for i := 1; i < n && d > 0; i++ {
+		d *= 2
+		if p.maxBackoff > 0 && d >= p.maxBackoff {
+			return p.maxBackoff
+		}
+	}
+	if p.maxBackoff > 0 && d > p.maxBackoff {
+		return p.maxBackoff
+	}
+	return d
+}
+
+func isConnectFailure(err error) bool {
+	if errors.Is(err, fasthttp.ErrDialTimeout) || errors.Is(err, fasthttp.ErrNoFreeConns) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, fasthttp.ErrTimeout) || (errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -0,0 +1,105 @@
+package entry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Shutdown stops the entry gracefully. Draining reports true for drain first,
+// so /readyz of the admin listeners fails and load balancers stop sending new
+// traffic, then the listeners are closed and the requests in flight, backend
+// calls included, are given until timeout to complete. It returns an error
+// when some of them did not.
+//
+// fiber v2.20 has no ShutdownWithTimeout, so its Shutdown is bounded here.
+func (e *Entry) Shutdown(drain time.Duration, timeout time.Duration) error {
+	atomic.StoreInt32(&e.draining, 1)
+	if drain > 0 {
+		fmt.Printf("[entry] draining for %v, %d requests in flight\n", drain, e.InFlight())
+		time.Sleep(drain)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	fmt.Printf("[entry] closing listeners, %d requests in flight\n", e.InFlight())
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Server.Shutdown()
+	}()
+	for _, server := range e.adminServers {
+		go server.Shutdown(ctx)
+	}
+	// sends a GOAWAY to h2 connections, closed once their streams are done
+	if e.h2Base != nil {
+		go e.h2Base.Shutdown(ctx)
+	}
+
+	// fasthttp waits for keep-alive connections to be closed by their
+	// clients, close those waiting for a request until it is done
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for stopped := false; !stopped; {
+		select {
+		case err := <-done:
+			if err != nil {
+				return fmt.Errorf("[entry] shutdown error: %v", err)
+			}
+			stopped = true
+		case <-ticker.C:
+			e.closeIdleConns()
+		case <-ctx.Done():
+			return fmt.Errorf("[entry] %d requests still in flight after %v", e.InFlight(), timeout)
+		}
+	}
+
+	// fasthttp closes a connection once its handler returned, make sure no
+	// proxied request is left anyway
+	for e.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("[entry] %d requests still in flight after %v", e.InFlight(), timeout)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// InFlight returns the number of requests being proxied.
+func (e *Entry) InFlight() int64 {
+	return atomic.LoadInt64(&e.inflight)
+}
+
+// Draining reports whether Shutdown was called.
+func (e *Entry) Draining() bool {
+	return atomic.LoadInt32(&e.draining) == 1
+}
+
+// trackConn is the ConnState hook of the server, it keeps the connections
+// waiting for a request so Shutdown can close them.
+func (e *Entry) trackConn(conn net.Conn, state fasthttp.ConnState) {
+	e.connsLock.Lock()
+	defer e.connsLock.Unlock()
+
+	switch state {
+	case fasthttp.StateNew, fasthttp.StateIdle:
+		e.idleConns[conn] = struct{}{}
+	default:
+		delete(e.idleConns, conn)
+	}
+}
+
+func (e *Entry) closeIdleConns() {
+	e.connsLock.Lock()
+	defer e.connsLock.Unlock()
+
+	for conn := range e.idleConns {
+		conn.Close()
+		delete(e.idleConns, conn)
+	}
+}
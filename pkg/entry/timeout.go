@@ -0,0 +1,54 @@
+package entry
+
+import (
+	"strconv"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+
+	v1alpha1 "github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+// TimeoutHeader carries the milliseconds left to serve a request. The proxy
+// sets it on upstream requests, and a client may send a smaller value than
+// the timeout of the route to shorten it.
+const TimeoutHeader = "X-Request-Timeout-Ms"
+
+// deadline returns when the request served by spec must be done, or the zero
+// time when it has no timeout.
+func (e *Entry) deadline(c *fiber.Ctx, spec *v1alpha1.RouteSpec, start time.Time) time.Time {
+	timeout := e.DefaultTimeout
+	if spec.Timeout != nil {
+		timeout = spec.Timeout.Duration
+	}
+	ms, err := strconv.ParseInt(string(c.Request().Header.Peek(TimeoutHeader)), 10, 64)
+	if err == nil && ms > 0 {
+		if d := time.Duration(ms) * time.Millisecond; timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
+
+// callTimeout returns the timeout of the next call to a target: the least of
+// the time left before deadline and the positive limits. It returns false
+// once deadline has passed.
+func callTimeout(deadline time.Time, limits ...time.Duration) (time.Duration, bool) {
+	var timeout time.Duration
+	if !deadline.IsZero() {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return 0, false
+		}
+	}
+	for _, limit := range limits {
+		if limit > 0 && (timeout <= 0 || limit < timeout) {
+			timeout = limit
+		}
+	}
+	return timeout, true
+}
@@ -0,0 +1,36 @@
+package entry
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCallTimeout checks that the per try and call limits bound a call
+// only within the deadline of the request.
+func TestCallTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		noDeadline bool
+		left       time.Duration
+		limits     []time.Duration
+		want       time.Duration
+		ok         bool
+	}{
+		{name: "no deadline nor limit", noDeadline: true, ok: true},
+		{name: "limit without deadline", noDeadline: true, limits: []time.Duration{time.Second}, want: time.Second, ok: true},
+		{name: "per try within deadline", left: time.Minute, limits: []time.Duration{time.Second}, want: time.Second, ok: true},
+		{name: "deadline before per try", left: time.Second, limits: []time.Duration{time.Minute}, want: time.Second, ok: true},
+		{name: "least limit", left: time.Minute, limits: []time.Duration{2 * time.Second, 0, time.Second}, want: time.Second, ok: true},
+		{name: "deadline passed", left: -time.Second, limits: []time.Duration{time.Second}},
+	}
+	for _, tt := range tests {
+		var deadline time.Time
+		if !tt.noDeadline {
+			deadline = time.Now().Add(tt.left)
+		}
+		got, ok := callTimeout(deadline, tt.limits...)
+		if ok != tt.ok || got.Round(100*time.Millisecond) != tt.want {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
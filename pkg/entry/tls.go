@@ -0,0 +1,92 @@
+package entry
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"golang.org/x/net/http2"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSVersion parses a TLS version like "1.2".
+func TLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls version %q, known: 1.0, 1.1, 1.2, 1.3", version)
+	}
+	return v, nil
+}
+
+// CipherSuites parses a comma separated list of cipher suite names, like
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Insecure suites are refused. An
+// empty list returns nil, which keeps the Go defaults.
+func CipherSuites(list string) ([]uint16, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(list, ",") {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// tlsConfig returns the configuration of HTTPS listeners, selecting
+// certificates from Certs by SNI. ALPN offers h2 first, the connections
+// negotiating it are served by serveH2.
+func (e *Entry) tlsConfig() *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if e.TLSConfig != nil {
+		config = e.TLSConfig.Clone()
+	}
+	config.GetCertificate = e.Certs.GetCertificate
+	config.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+	return config
+}
+
+// httpsListener returns the first HTTPS listener, if any.
+func (e *Entry) httpsListener() *Listener {
+	for i := range e.Listeners {
+		if e.Listeners[i].Kind == ListenerHTTPS {
+			return &e.Listeners[i]
+		}
+	}
+	return nil
+}
+
+// redirectHTTP redirects requests that did not come over TLS to the same
+// url on the first HTTPS listener.
+func (e *Entry) redirectHTTP(c *fiber.Ctx) error {
+	if c.Context().IsTLS() {
+		return c.Next()
+	}
+
+	host := c.Hostname()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(e.httpsListener().Addr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	return c.Redirect("https://"+host+string(c.Request().RequestURI()), fiber.StatusPermanentRedirect)
+}
@@ -0,0 +1,51 @@
+package entry
+
+import (
+	"fmt"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
+
+	"github.com/seveirbian/edgeserverless/pkg/tracing"
+)
+
+// startSpan starts the server span of the request, continuing the trace of
+// the traceparent header if there is one.
+func (e *Entry) startSpan(c *fiber.Ctx) *tracing.Span {
+	req := c.Request()
+	parent := tracing.Extract(string(req.Header.Peek(tracing.TraceparentHeader)),
+		string(req.Header.Peek(tracing.TracestateHeader)))
+
+	span := e.Tracer.Start(parent, "route-proxy", tracing.SpanKindServer)
+	span.SetAttribute("http.method", string(req.Header.Method()))
+	span.SetAttribute("http.host", string(req.Host()))
+	span.SetAttribute("http.target", string(req.RequestURI()))
+	span.SetAttribute("http.request_id", requestID(c))
+	return span
+}
+
+// endSpan records the response of the request on span and ends it.
+func endSpan(c *fiber.Ctx, span *tracing.Span) {
+	status := c.Response().StatusCode()
+	span.SetAttribute("http.status_code", status)
+	if errCode, ok := c.Locals(errorCodeKey).(string); ok {
+		span.SetAttribute("error.code", errCode)
+	}
+	if status >= fiber.StatusInternalServerError {
+		span.SetError(fmt.Errorf("%d %s", status, utils.StatusMessage(status)))
+	}
+	span.End()
+}
+
+// inject sets the trace context of span on the upstream request.
+func inject(req *fasthttp.Request, span *tracing.Span) {
+	sc := span.Context()
+	if !sc.IsValid() {
+		return
+	}
+	req.Header.Set(tracing.TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		req.Header.Set(tracing.TracestateHeader, sc.TraceState)
+	}
+}
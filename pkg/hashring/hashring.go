@@ -0,0 +1,81 @@
+package hashring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+// PointsPerRatio is the number of points a target has on a ring for each
+// unit of its ratio.
+const PointsPerRatio = 16
+
+// Ring is a consistent hash ring of targets. Each target has points in
+// proportion to its ratio, so keys spread across targets by ratio and most
+// keys keep their target when the others change.
+type Ring struct {
+	points []point
+}
+
+type point struct {
+	hash   uint64
+	target int
+}
+
+// New returns the ring of targets. Targets with a zero ratio have no point.
+func New(targets []v1alpha1.RouteTarget) *Ring {
+	r := &Ring{}
+	for i, t := range targets {
+		id := t.Type + "/" + t.Target + "#"
+		for j := 0; j < int(t.Ratio)*PointsPerRatio; j++ {
+			r.points = append(r.points, point{hash: Hash(id + strconv.Itoa(j)), target: i})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// Pick returns the index of the target of key: the first target clockwise
+// from the hash of key for which ok returns true, or -1 when there is none.
+func (r *Ring) Pick(key string, ok func(target int) bool) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+
+	h := Hash(key)
+	start := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	skipped := map[int]bool{}
+	for n := 0; n < len(r.points); n++ {
+		target := r.points[(start+n)%len(r.points)].target
+		if skipped[target] {
+			continue
+		}
+		if ok(target) {
+			return target
+		}
+		skipped[target] = true
+	}
+	return -1
+}
+
+// Hash returns the 64-bit FNV-1a hash of s, mixed so that similar strings
+// spread evenly around the ring.
+func Hash(s string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	h := f.Sum64()
+
+	// splitmix64 finalizer
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
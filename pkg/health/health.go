@@ -0,0 +1,403 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+)
+
+const (
+	DefaultInterval           = 10 * time.Second
+	DefaultTimeout            = 2 * time.Second
+	DefaultHealthyThreshold   = 2
+	DefaultUnhealthyThreshold = 3
+	DefaultEjectionTime       = 30 * time.Second
+)
+
+// Manager tracks the health of the targets of every rule in the rules table,
+// keyed by the rulesmanager.URIKey of the rule. Targets of untracked rules
+// are always healthy.
+type Manager struct {
+	mu       sync.RWMutex
+	routes   map[string]*routeHealth
+	onChange func(route string)
+}
+
+type routeHealth struct {
+	key       string
+	uri       string
+	matchType string
+	config    config
+	targets   map[string]*targetHealth
+	stop      chan struct{}
+}
+
+type config struct {
+	path                string
+	interval            time.Duration
+	timeout             time.Duration
+	healthyThreshold    int
+	unhealthyThreshold  int
+	consecutiveFailures int
+	ejectionTime        time.Duration
+}
+
+type targetHealth struct {
+	mu sync.Mutex
+
+	target v1alpha1.RouteTarget
+	probed bool
+
+	// active probing
+	probeHealthy bool
+	successes    int
+	failures     int
+	lastError    string
+
+	// passive ejection
+	consecutiveFailures int
+	ejectedUntil        time.Time
+}
+
+// TargetStatus is the health of one target as served on the debug endpoint.
+type TargetStatus struct {
+	URI                 string     `json:"uri"`
+	MatchType           string     `json:"matchType,omitempty"`
+	Route               string     `json:"route"`
+	Type                string     `json:"type"`
+	Target              string     `json:"target"`
+	Healthy             bool       `json:"healthy"`
+	Probed              bool       `json:"probed"`
+	LastProbeError      string     `json:"lastProbeError,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	EjectedUntil        *time.Time `json:"ejectedUntil,omitempty"`
+}
+
+// RuleHealth counts the targets of a rule by health.
+type RuleHealth struct {
+	Targets int
+	// Unhealthy targets receive no traffic, ejected ones included.
+	Unhealthy int
+	Ejected   int
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		routes: map[string]*routeHealth{},
+	}
+}
+
+// Track starts checking the targets of rule, the rule of spec owned by the
+// route with key. A previous tracking of rule is replaced, unless nothing
+// about it changed, in which case the gathered health is kept.
+func (m *Manager) Track(rule string, key string, spec *v1alpha1.RouteSpec) {
+	if m.tracking(rule, key, spec) {
+		return
+	}
+
+	m.Untrack(rule)
+	if spec.HealthCheck == nil {
+		return
+	}
+
+	rh := &routeHealth{
+		key:       key,
+		uri:       spec.URI,
+		matchType: spec.MatchType,
+		config:    newConfig(spec.HealthCheck),
+		targets:   map[string]*targetHealth{},
+		stop:      make(chan struct{}),
+	}
+	for _, t := range spec.AllTargets() {
+		id := targetID(t)
+		if _, ok := rh.targets[id]; ok {
+			continue
+		}
+		th := &targetHealth{target: t, probeHealthy: true}
+		rh.targets[id] = th
+
+		if rh.config.path == "" {
+			continue
+		}
+		bke, err := backend.GetBackend(t.Type)
+		if err != nil {
+			continue
+		}
+		if prober, ok := bke.(backend.Prober); ok {
+			th.probed = true
+			go m.probe(rh, th, prober)
+		}
+	}
+
+	m.mu.Lock()
+	m.routes[rule] = rh
+	m.mu.Unlock()
+}
+
+func (m *Manager) tracking(rule string, key string, spec *v1alpha1.RouteSpec) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rh, ok := m.routes[rule]
+	if !ok || spec.HealthCheck == nil {
+		return false
+	}
+	if rh.key != key || rh.uri != spec.URI || rh.config != newConfig(spec.HealthCheck) {
+		return false
+	}
+
+	ids := map[string]bool{}
+	for _, t := range spec.AllTargets() {
+		ids[targetID(t)] = true
+	}
+	if len(ids) != len(rh.targets) {
+		return false
+	}
+	for id := range ids {
+		if _, ok := rh.targets[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// OnChange sets f to be called with the key of the route owning a target
+// whenever the target turns healthy or unhealthy.
+func (m *Manager) OnChange(f func(route string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = f
+}
+
+func (m *Manager) changed(rh *routeHealth) {
+	m.mu.RLock()
+	f := m.onChange
+	m.mu.RUnlock()
+	if f != nil {
+		f(rh.key)
+	}
+}
+
+// Untrack stops checking the targets of rule.
+func (m *Manager) Untrack(rule string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rh, ok := m.routes[rule]; ok {
+		close(rh.stop)
+		delete(m.routes, rule)
+	}
+}
+
+// Healthy reports whether target of rule may receive traffic.
+func (m *Manager) Healthy(rule string, target v1alpha1.RouteTarget) bool {
+	th := m.target(rule, target)
+	if th == nil {
+		return true
+	}
+
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return th.healthy(time.Now())
+}
+
+// Report records the outcome of a request to target of rule for passive
+// ejection.
+func (m *Manager) Report(rule string, target v1alpha1.RouteTarget, success bool) {
+	m.mu.RLock()
+	rh, ok := m.routes[rule]
+	m.mu.RUnlock()
+	if !ok || rh.config.consecutiveFailures <= 0 {
+		return
+	}
+	th, ok := rh.targets[targetID(target)]
+	if !ok {
+		return
+	}
+
+	th.mu.Lock()
+	if success {
+		th.consecutiveFailures = 0
+		th.mu.Unlock()
+		return
+	}
+	th.consecutiveFailures++
+	now := time.Now()
+	eject := th.consecutiveFailures >= rh.config.consecutiveFailures && !now.Before(th.ejectedUntil)
+	if eject {
+		th.consecutiveFailures = 0
+		th.ejectedUntil = now.Add(rh.config.ejectionTime)
+	}
+	th.mu.Unlock()
+
+	if eject {
+		fmt.Printf("[health] eject %s of %s for %v\n", target.Target, rh.uri, rh.config.ejectionTime)
+		m.changed(rh)
+		time.AfterFunc(rh.config.ejectionTime, func() { m.changed(rh) })
+	}
+}
+
+// RuleHealth returns the health of the targets of rule, false when it is not
+// tracked.
+func (m *Manager) RuleHealth(rule string) (RuleHealth, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rh, ok := m.routes[rule]
+	if !ok {
+		return RuleHealth{}, false
+	}
+	health := RuleHealth{Targets: len(rh.targets)}
+	now := time.Now()
+	for _, th := range rh.targets {
+		th.mu.Lock()
+		if !th.healthy(now) {
+			health.Unhealthy++
+		}
+		if now.Before(th.ejectedUntil) {
+			health.Ejected++
+		}
+		th.mu.Unlock()
+	}
+	return health, true
+}
+
+// Status returns the health of every tracked target.
+func (m *Manager) Status() []TargetStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := []TargetStatus{}
+	now := time.Now()
+	for _, rh := range m.routes {
+		for _, th := range rh.targets {
+			th.mu.Lock()
+			status := TargetStatus{
+				URI:                 rh.uri,
+				MatchType:           rh.matchType,
+				Route:               rh.key,
+				Type:                th.target.Type,
+				Target:              th.target.Target,
+				Healthy:             th.healthy(now),
+				Probed:              th.probed,
+				LastProbeError:      th.lastError,
+				ConsecutiveFailures: th.consecutiveFailures,
+			}
+			if now.Before(th.ejectedUntil) {
+				until := th.ejectedUntil
+				status.EjectedUntil = &until
+			}
+			th.mu.Unlock()
+			statuses = append(statuses, status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].URI != statuses[j].URI {
+			return statuses[i].URI < statuses[j].URI
+		}
+		if statuses[i].MatchType != statuses[j].MatchType {
+			return statuses[i].MatchType < statuses[j].MatchType
+		}
+		return statuses[i].Target < statuses[j].Target
+	})
+	return statuses
+}
+
+// ServeHTTP serves Status as JSON.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Status())
+}
+
+func (m *Manager) target(rule string, target v1alpha1.RouteTarget) *targetHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rh, ok := m.routes[rule]
+	if !ok {
+		return nil
+	}
+	return rh.targets[targetID(target)]
+}
+
+func (m *Manager) probe(rh *routeHealth, th *targetHealth, prober backend.Prober) {
+	ticker := time.NewTicker(rh.config.interval)
+	defer ticker.Stop()
+
+	for {
+		err := prober.Probe(th.target.Target, rh.config.path, rh.config.timeout)
+
+		th.mu.Lock()
+		was := th.probeHealthy
+		if err == nil {
+			th.successes++
+			th.failures = 0
+			th.lastError = ""
+			if th.successes >= rh.config.healthyThreshold {
+				th.probeHealthy = true
+			}
+		} else {
+			th.failures++
+			th.successes = 0
+			th.lastError = err.Error()
+			if th.failures >= rh.config.unhealthyThreshold {
+				th.probeHealthy = false
+			}
+		}
+		now := th.probeHealthy
+		th.mu.Unlock()
+
+		if was != now {
+			fmt.Printf("[health] target %s healthy %v: %v\n", th.target.Target, now, err)
+			m.changed(rh)
+		}
+
+		select {
+		case <-rh.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (th *targetHealth) healthy(now time.Time) bool {
+	return th.probeHealthy && !now.Before(th.ejectedUntil)
+}
+
+func newConfig(hc *v1alpha1.HealthCheck) config {
+	c := config{
+		path:                hc.Path,
+		interval:            DefaultInterval,
+		timeout:             DefaultTimeout,
+		healthyThreshold:    DefaultHealthyThreshold,
+		unhealthyThreshold:  DefaultUnhealthyThreshold,
+		consecutiveFailures: int(hc.ConsecutiveFailures),
+		ejectionTime:        DefaultEjectionTime,
+	}
+	if hc.Interval != nil && hc.Interval.Duration > 0 {
+		c.interval = hc.Interval.Duration
+	}
+	if hc.Timeout != nil && hc.Timeout.Duration > 0 {
+		c.timeout = hc.Timeout.Duration
+	}
+	if hc.HealthyThreshold > 0 {
+		c.healthyThreshold = int(hc.HealthyThreshold)
+	}
+	if hc.UnhealthyThreshold > 0 {
+		c.unhealthyThreshold = int(hc.UnhealthyThreshold)
+	}
+	if hc.EjectionTime != nil && hc.EjectionTime.Duration > 0 {
+		c.ejectionTime = hc.EjectionTime.Duration
+	}
+	return c
+}
+
+func targetID(t v1alpha1.RouteTarget) string {
+	return t.Type + "/" + t.Target
+}
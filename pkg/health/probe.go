@@ -0,0 +1,42 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Check is one condition of a probe, it returns nil when the condition holds.
+type Check struct {
+	Name  string
+	Check func() error
+}
+
+// Probe serves a Kubernetes style probe: 200 "ok" when every check passes,
+// 503 listing the failed checks otherwise. ?verbose lists every check.
+type Probe []Check
+
+func (p Probe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, verbose := r.URL.Query()["verbose"]
+
+	var b strings.Builder
+	failed := false
+	for _, check := range p {
+		if err := check.Check(); err != nil {
+			failed = true
+			fmt.Fprintf(&b, "[-]%s failed: %v\n", check.Name, err)
+		} else if verbose {
+			fmt.Fprintf(&b, "[+]%s ok\n", check.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(&b, "%s check failed\n", strings.TrimPrefix(r.URL.Path, "/"))
+	} else {
+		b.WriteString("ok\n")
+	}
+	fmt.Fprint(w, b.String())
+}
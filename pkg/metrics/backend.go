@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+)
+
+var poolConnsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "backend", "pool_connections"),
+	"Open connections in backend client pools, by upstream address.",
+	[]string{"backend", "address"}, nil)
+
+// poolCollector reads the pool stats of configured backends at scrape time.
+type poolCollector struct{}
+
+func init() {
+	Registry.MustRegister(poolCollector{})
+}
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConnsDesc
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range backend.Names() {
+		bke, err := backend.GetBackend(name)
+		if err != nil {
+			continue
+		}
+		statser, ok := bke.(backend.PoolStatser)
+		if !ok {
+			continue
+		}
+		for addr, conns := range statser.PoolStats().Conns {
+			ch <- prometheus.MustNewConstMetric(poolConnsDesc, prometheus.GaugeValue,
+				float64(conns), name, addr)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/seveirbian/edgeserverless/pkg/breaker"
+)
+
+var (
+	circuitLabels = []string{"uri", "match_type", "backend", "target"}
+
+	circuitStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit", "state"),
+		"State of target circuit breakers, 1 for the current state.",
+		append(circuitLabels, "state"), nil)
+	circuitInflightDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit", "inflight_requests"),
+		"Requests in flight to targets with a circuit breaker.",
+		circuitLabels, nil)
+	circuitPendingDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit", "pending_requests"),
+		"Requests waiting for a slot of a target.",
+		circuitLabels, nil)
+	circuitOpensDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit", "opens_total"),
+		"Times target circuit breakers opened.",
+		circuitLabels, nil)
+	circuitRejectedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit", "rejected_requests_total"),
+		"Requests rejected by an open circuit breaker or a full target.",
+		circuitLabels, nil)
+)
+
+var circuitStates = []string{breaker.StateClosed, breaker.StateOpen, breaker.StateHalfOpen}
+
+// circuitCollector reads the circuit breakers returned by statuses at
+// scrape time.
+type circuitCollector struct {
+	statuses func() []breaker.Status
+}
+
+// RegisterCircuits exports the circuit breakers returned by statuses.
+func RegisterCircuits(statuses func() []breaker.Status) {
+	Registry.MustRegister(circuitCollector{statuses: statuses})
+}
+
+func (circuitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- circuitStateDesc
+	ch <- circuitInflightDesc
+	ch <- circuitPendingDesc
+	ch <- circuitOpensDesc
+	ch <- circuitRejectedDesc
+}
+
+func (c circuitCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.statuses() {
+		for _, state := range circuitStates {
+			value := 0.0
+			if state == s.State {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(circuitStateDesc, prometheus.GaugeValue,
+				value, s.URI, s.MatchType, s.Type, s.Target, state)
+		}
+		ch <- prometheus.MustNewConstMetric(circuitInflightDesc, prometheus.GaugeValue,
+			float64(s.InFlight), s.URI, s.MatchType, s.Type, s.Target)
+		ch <- prometheus.MustNewConstMetric(circuitPendingDesc, prometheus.GaugeValue,
+			float64(s.Pending), s.URI, s.MatchType, s.Type, s.Target)
+		ch <- prometheus.MustNewConstMetric(circuitOpensDesc, prometheus.CounterValue,
+			float64(s.Opens), s.URI, s.MatchType, s.Type, s.Target)
+		ch <- prometheus.MustNewConstMetric(circuitRejectedDesc, prometheus.CounterValue,
+			float64(s.Rejected), s.URI, s.MatchType, s.Type, s.Target)
+	}
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "edgeserverless"
+
+// Registry holds every metric exported by route-proxy.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Requests counts requests served by the entry.
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Requests served by the entry, by route, target, backend and status code.",
+	}, []string{"namespace", "route", "target", "backend", "code"})
+
+	// RequestDuration observes the time to serve a request, retries included.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time to serve a request, retries included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace", "route", "target", "backend", "code"})
+
+	// RequestErrors counts requests answered with an entry error response.
+	RequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_errors_total",
+		Help:      "Requests answered with an error by the entry, by error code.",
+	}, []string{"namespace", "route", "target", "backend", "error"})
+
+	// BackendDuration observes single backend invocations.
+	BackendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "invoke_duration_seconds",
+		Help:      "Time of single backend invocations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "result"})
+
+	// BackendInflight is the number of backend invocations in progress.
+	BackendInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "inflight_requests",
+		Help:      "Backend invocations in progress.",
+	}, []string{"backend"})
+
+	// SyncDuration observes RouteController sync handler runs.
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "sync_duration_seconds",
+		Help:      "Time to sync one Route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		Requests,
+		RequestDuration,
+		RequestErrors,
+		BackendDuration,
+		BackendInflight,
+		SyncDuration,
+	)
+}
+
+// RegisterRulesLoaded exports the size of the rules table as returned by count.
+func RegisterRulesLoaded(count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "rules_loaded",
+		Help:      "Rules currently loaded in the rules table.",
+	}, func() float64 {
+		return float64(count())
+	}))
+}
+
+// Handler serves the metrics in Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// MaxKeys bounds the buckets of one Limiter. When it is reached, buckets
+// that refilled are dropped, as they are the same as new ones. Buckets in
+// use are kept so rotating keys does not get a client a full bucket back,
+// new keys share one overflow bucket until room is made.
+var MaxKeys = 10000
+
+// Limiter is a token bucket per key: each bucket holds up to burst tokens,
+// refills at rate tokens per second and a request takes one token.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	overflow *bucket
+	// evicted is when refilled buckets were last looked for
+	evicted time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Result is the outcome of Allow.
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket.
+	Limit int
+	// Remaining is the number of whole tokens left.
+	Remaining int
+	// RetryAfter is how long until a token is available, 0 when one is.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// New returns a Limiter refilling rate tokens per second into buckets of
+// burst tokens, rate when burst is not positive.
+func New(rate float64, burst int) *Limiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &Limiter{
+		rate:     rate,
+		burst:    float64(burst),
+		buckets:  map[string]*bucket{},
+		overflow: &bucket{tokens: float64(burst)},
+	}
+}
+
+// Allow takes a token from the bucket of key at now.
+func (l *Limiter) Allow(key string, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= MaxKeys {
+			l.evict(now)
+		}
+		if len(l.buckets) < MaxKeys {
+			b = &bucket{tokens: l.burst, last: now}
+			l.buckets[key] = b
+		} else {
+			b = l.overflow
+		}
+	}
+	b.refill(now, l.rate, l.burst)
+
+	result := Result{Limit: int(l.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.wait(1 - b.tokens)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = l.wait(l.burst - b.tokens)
+
+	return result
+}
+
+// Len returns the number of buckets held.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buckets)
+}
+
+// evict drops the buckets that refilled. As it goes through all of them, it
+// does so at most once per token refilled. Must be called with mu held.
+func (l *Limiter) evict(now time.Time) {
+	if now.Sub(l.evicted) < l.wait(1) {
+		return
+	}
+	l.evicted = now
+	for key, b := range l.buckets {
+		if b.refill(now, l.rate, l.burst); b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// wait returns how long refilling tokens takes.
+func (l *Limiter) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / l.rate * float64(time.Second)))
+}
+
+func (b *bucket) refill(now time.Time, rate, burst float64) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+		b.last = now
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestAllow checks the tokens taken from and refilled into buckets.
+func TestAllow(t *testing.T) {
+	l := New(2, 3)
+	start := time.Now()
+
+	tests := []struct {
+		name       string
+		after      time.Duration
+		key        string
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+		reset      time.Duration
+	}{
+		{name: "full bucket", key: "a", allowed: true, remaining: 2, reset: 500 * time.Millisecond},
+		{name: "burst", key: "a", allowed: true, remaining: 1, reset: time.Second},
+		{name: "last token", key: "a", allowed: true, remaining: 0, reset: 1500 * time.Millisecond},
+		{name: "empty bucket", key: "a", retryAfter: 500 * time.Millisecond, reset: 1500 * time.Millisecond},
+		{name: "other key", key: "b", allowed: true, remaining: 2, reset: 500 * time.Millisecond},
+		{name: "refilled token", after: 500 * time.Millisecond, key: "a", allowed: true, remaining: 0,
+			reset: 1500 * time.Millisecond},
+		{name: "partly refilled", after: 750 * time.Millisecond, key: "a", retryAfter: 250 * time.Millisecond,
+			reset: 1250 * time.Millisecond},
+		{name: "refill stops at burst", after: time.Minute, key: "a", allowed: true, remaining: 2,
+			reset: 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := l.Allow(tt.key, start.Add(tt.after))
+		want := Result{Allowed: tt.allowed, Limit: 3, Remaining: tt.remaining, RetryAfter: tt.retryAfter, Reset: tt.reset}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, want)
+		}
+	}
+	if n := l.Len(); n != 2 {
+		t.Errorf("%d buckets, want 2", n)
+	}
+}
+
+// TestMaxKeys checks that the buckets of a Limiter stay bounded, dropping
+// refilled ones first.
+func TestMaxKeys(t *testing.T) {
+	defer func(max int) { MaxKeys = max }(MaxKeys)
+	MaxKeys = 2
+
+	l := New(1, 2)
+	start := time.Now()
+	l.Allow("refilled", start)
+	l.Allow("empty", start.Add(time.Minute))
+	l.Allow("empty", start.Add(time.Minute))
+	l.Allow("new", start.Add(time.Minute))
+
+	if n := l.Len(); n != 2 {
+		t.Fatalf("%d buckets, want 2", n)
+	}
+	if got := l.Allow("empty", start.Add(time.Minute)); got.Allowed {
+		t.Errorf("empty bucket was dropped instead of the refilled one")
+	}
+}
+
+// TestKeyRotation checks that flooding a full Limiter with new keys neither
+// drops a bucket in use nor lets the new keys exceed the limit.
+func TestKeyRotation(t *testing.T) {
+	defer func(max int) { MaxKeys = max }(MaxKeys)
+	MaxKeys = 3
+
+	l := New(1, 2)
+	now := time.Now()
+	l.Allow("client", now)
+	l.Allow("client", now)
+
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if l.Allow(fmt.Sprintf("rotated-%d", i), now).Allowed {
+			allowed++
+		}
+	}
+	// a token from each of the two buckets left, then the overflow ones
+	if allowed != 4 {
+		t.Errorf("%d requests of rotated keys allowed, want 4", allowed)
+	}
+	if got := l.Allow("client", now); got.Allowed {
+		t.Errorf("empty bucket of client dropped by rotated keys")
+	}
+	if n := l.Len(); n != 3 {
+		t.Errorf("%d buckets, want 3", n)
+	}
+}
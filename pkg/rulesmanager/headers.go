@@ -0,0 +1,188 @@
+package rulesmanager
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+// HeaderVariables are the variables header values may refer to as ${name}.
+var HeaderVariables = map[string]string{
+	"client_ip":  "IP address of the client",
+	"host":       "Host of the request",
+	"method":     "method of the request",
+	"path":       "path of the request, before any rewrite",
+	"scheme":     "http or https, as received by the proxy",
+	"request_id": "X-Request-Id of the request",
+	"route":      "namespace/name of the route",
+	"uri":        "uri of the route",
+	"target":     "target the request is sent to",
+	"backend":    "backend of the target",
+}
+
+// headers managed by fasthttp, they can not be changed by rules
+var reservedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// Headers is a compiled v1alpha1.HeaderRules. A nil Headers changes
+// nothing.
+type Headers struct {
+	remove []string
+	set    []headerValue
+	add    []headerValue
+}
+
+type headerValue struct {
+	name  string
+	value template
+}
+
+// template is a header value split into literals and variables.
+type template []templatePart
+
+type templatePart struct {
+	literal  string
+	variable string
+}
+
+// HeaderWriter is implemented by the request and response headers of
+// fasthttp.
+type HeaderWriter interface {
+	Set(key, value string)
+	Add(key, value string)
+	Del(key string)
+}
+
+// ValidateHeaders checks the header rules of spec.
+func ValidateHeaders(spec *v1alpha1.RouteSpec) error {
+	_, _, err := compileHeaders(spec)
+	return err
+}
+
+func compileHeaders(spec *v1alpha1.RouteSpec) (request *Headers, response *Headers, err error) {
+	if spec.Headers == nil {
+		return nil, nil, nil
+	}
+	if request, err = compileHeaderRules(spec.Headers.Request); err != nil {
+		return nil, nil, fmt.Errorf("request: %v", err)
+	}
+	if response, err = compileHeaderRules(spec.Headers.Response); err != nil {
+		return nil, nil, fmt.Errorf("response: %v", err)
+	}
+	return request, response, nil
+}
+
+func compileHeaderRules(rules *v1alpha1.HeaderRules) (*Headers, error) {
+	if rules == nil {
+		return nil, nil
+	}
+
+	h := &Headers{}
+	for i, name := range rules.Remove {
+		if err := validateHeaderName(name); err != nil {
+			return nil, fmt.Errorf("remove[%d]: %v", i, err)
+		}
+		h.remove = append(h.remove, name)
+	}
+	var err error
+	if h.set, err = compileHeaderValues(rules.Set); err != nil {
+		return nil, fmt.Errorf("set%v", err)
+	}
+	if h.add, err = compileHeaderValues(rules.Add); err != nil {
+		return nil, fmt.Errorf("add%v", err)
+	}
+	return h, nil
+}
+
+func compileHeaderValues(values []v1alpha1.HeaderValue) ([]headerValue, error) {
+	var out []headerValue
+	for i, v := range values {
+		if err := validateHeaderName(v.Name); err != nil {
+			return nil, fmt.Errorf("[%d]: %v", i, err)
+		}
+		t, err := parseTemplate(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %v", i, err)
+		}
+		out = append(out, headerValue{name: v.Name, value: t})
+	}
+	return out, nil
+}
+
+func validateHeaderName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return fmt.Errorf("%q is not a valid header name", name)
+		}
+	}
+	if reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+		return fmt.Errorf("header %s can not be changed", name)
+	}
+	return nil
+}
+
+// parseTemplate splits value at ${name} variables.
+func parseTemplate(value string) (template, error) {
+	var t template
+	for value != "" {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			t = append(t, templatePart{literal: value})
+			break
+		}
+		if i > 0 {
+			t = append(t, templatePart{literal: value[:i]})
+		}
+		j := strings.IndexByte(value[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("unterminated variable in %q", value)
+		}
+		name := value[i+2 : i+j]
+		if _, ok := HeaderVariables[name]; !ok {
+			return nil, fmt.Errorf("unknown variable ${%s}", name)
+		}
+		t = append(t, templatePart{variable: name})
+		value = value[i+j+1:]
+	}
+	return t, nil
+}
+
+func (t template) expand(vars func(name string) string) string {
+	if len(t) == 1 && t[0].variable == "" {
+		return t[0].literal
+	}
+	var b strings.Builder
+	for _, p := range t {
+		if p.variable != "" {
+			b.WriteString(vars(p.variable))
+		} else {
+			b.WriteString(p.literal)
+		}
+	}
+	return b.String()
+}
+
+// Apply removes, sets and then adds headers of w, in that order, expanding
+// variables with vars.
+func (h *Headers) Apply(w HeaderWriter, vars func(name string) string) {
+	if h == nil {
+		return
+	}
+	for _, name := range h.remove {
+		w.Del(name)
+	}
+	for _, v := range h.set {
+		w.Set(v.name, v.value.expand(vars))
+	}
+	for _, v := range h.add {
+		w.Add(v.name, v.value.expand(vars))
+	}
+}
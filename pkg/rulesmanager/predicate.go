@@ -0,0 +1,227 @@
+package rulesmanager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/hashring"
+)
+
+var (
+	// ErrMethodNotAllowed is returned by Match.Selected when the route does
+	// not serve the request method, or when the route has no default
+	// targets and every match only fails on its methods.
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	// ErrNoTargets is returned by Match.Selected when no match holds and the
+	// route has no default targets.
+	ErrNoTargets = errors.New("no targets for request")
+)
+
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"PATCH": true, "OPTIONS": true, "CONNECT": true, "TRACE": true,
+}
+
+// Request is the part of an incoming request that match predicates inspect.
+type Request interface {
+	Method() string
+	Header(name string) (string, bool)
+	Query(name string) (string, bool)
+	Cookie(name string) (string, bool)
+}
+
+type matcher struct {
+	methods []string
+	headers []valueMatcher
+	queries []valueMatcher
+	cookies []valueMatcher
+	targets []v1alpha1.RouteTarget
+}
+
+type valueMatcher struct {
+	name  string
+	value string
+	re    *regexp.Regexp
+}
+
+// ValidateMatches checks methods and match predicates of spec.
+func ValidateMatches(spec *v1alpha1.RouteSpec) error {
+	_, err := compileMatches(spec)
+	return err
+}
+
+func compileMatches(spec *v1alpha1.RouteSpec) ([]matcher, error) {
+	if err := validateMethods(spec.Methods); err != nil {
+		return nil, err
+	}
+
+	var matchers []matcher
+	for i, m := range spec.Matches {
+		if err := validateMethods(m.Methods); err != nil {
+			return nil, fmt.Errorf("matches[%d]: %v", i, err)
+		}
+		if len(m.Targets) == 0 {
+			return nil, fmt.Errorf("matches[%d]: at least one target is required", i)
+		}
+
+		mt := matcher{methods: m.Methods, targets: m.Targets}
+		var err error
+		if mt.headers, err = compileValues(m.Headers); err != nil {
+			return nil, fmt.Errorf("matches[%d].headers: %v", i, err)
+		}
+		if mt.queries, err = compileValues(m.Queries); err != nil {
+			return nil, fmt.Errorf("matches[%d].queries: %v", i, err)
+		}
+		if mt.cookies, err = compileValues(m.Cookies); err != nil {
+			return nil, fmt.Errorf("matches[%d].cookies: %v", i, err)
+		}
+		matchers = append(matchers, mt)
+	}
+
+	return matchers, nil
+}
+
+func validateMethods(methods []string) error {
+	for _, m := range methods {
+		if !knownMethods[m] {
+			return fmt.Errorf("unknown method %q", m)
+		}
+	}
+	return nil
+}
+
+func compileValues(values []v1alpha1.ValueMatch) ([]valueMatcher, error) {
+	var out []valueMatcher
+	for _, v := range values {
+		if v.Name == "" {
+			return nil, fmt.Errorf("name must not be empty")
+		}
+		if v.Value != "" && v.Regex != "" {
+			return nil, fmt.Errorf("%s: only one of value and regex may be set", v.Name)
+		}
+		vm := valueMatcher{name: v.Name, value: v.Value}
+		if v.Regex != "" {
+			re, err := regexp.Compile(v.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", v.Name, err)
+			}
+			vm.re = re
+		}
+		out = append(out, vm)
+	}
+	return out, nil
+}
+
+// Targets returns the targets of the match at index selected in
+// Spec.Matches, the default targets for -1, and their hash ring when the
+// route has a session affinity key.
+func (m *Match) Targets(selected int) ([]v1alpha1.RouteTarget, *hashring.Ring) {
+	var ring *hashring.Ring
+	if m.rings != nil {
+		ring = m.rings[selected+1]
+	}
+	if selected >= 0 {
+		return m.matchers[selected].targets, ring
+	}
+	return m.Spec.Targets, ring
+}
+
+// Selected returns the index in Spec.Matches of the match serving req, or
+// -1 when the route's default targets serve it.
+func (m *Match) Selected(req Request) (int, error) {
+	if !methodAllowed(m.Spec.Methods, req.Method()) {
+		return -1, ErrMethodNotAllowed
+	}
+
+	methodOnly := len(m.matchers) > 0
+	for i := range m.matchers {
+		if !m.matchers[i].valuesHold(req) {
+			methodOnly = false
+			continue
+		}
+		if methodAllowed(m.matchers[i].methods, req.Method()) {
+			return i, nil
+		}
+	}
+
+	switch {
+	case len(m.Spec.Targets) > 0:
+		return -1, nil
+	case methodOnly:
+		return -1, ErrMethodNotAllowed
+	}
+	return -1, ErrNoTargets
+}
+
+// AllowedMethods returns the methods of the route for the Allow header of
+// ErrMethodNotAllowed responses: those of the route, or when only matches
+// serve it, the union of theirs.
+func (m *Match) AllowedMethods() []string {
+	if len(m.Spec.Targets) > 0 || len(m.matchers) == 0 {
+		return m.Spec.Methods
+	}
+
+	var methods []string
+	seen := map[string]bool{}
+	for _, mt := range m.matchers {
+		if len(mt.methods) == 0 {
+			return m.Spec.Methods
+		}
+		for _, method := range mt.methods {
+			if !seen[method] && methodAllowed(m.Spec.Methods, method) {
+				seen[method] = true
+				methods = append(methods, method)
+			}
+		}
+	}
+	return methods
+}
+
+// valuesHold reports whether the header, query and cookie predicates of mt
+// hold for req.
+func (mt *matcher) valuesHold(req Request) bool {
+	for _, h := range mt.headers {
+		if !h.holds(req.Header) {
+			return false
+		}
+	}
+	for _, q := range mt.queries {
+		if !q.holds(req.Query) {
+			return false
+		}
+	}
+	for _, c := range mt.cookies {
+		if !c.holds(req.Cookie) {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm *valueMatcher) holds(get func(string) (string, bool)) bool {
+	v, ok := get(vm.name)
+	switch {
+	case !ok:
+		return false
+	case vm.re != nil:
+		return vm.re.MatchString(v)
+	case vm.value != "":
+		return v == vm.value
+	}
+	return true
+}
+
+func methodAllowed(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package rulesmanager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+// testRequest is a Request with a method and headers.
+type testRequest struct {
+	method  string
+	headers map[string]string
+}
+
+func (r testRequest) Method() string { return r.method }
+
+func (r testRequest) Header(name string) (string, bool) {
+	v, ok := r.headers[name]
+	return v, ok
+}
+
+func (r testRequest) Query(name string) (string, bool) { return "", false }
+
+func (r testRequest) Cookie(name string) (string, bool) { return "", false }
+
+// TestSelectedMethods checks when a request is refused for its method and
+// the methods then allowed.
+func TestSelectedMethods(t *testing.T) {
+	target := []v1alpha1.RouteTarget{{Target: "a", Type: "k8sservice", Ratio: 1}}
+	matchesOnly := v1alpha1.RouteSpec{
+		URI: "a.com/x",
+		Matches: []v1alpha1.RouteMatch{
+			{Methods: []string{"GET", "HEAD"}, Targets: target},
+			{Methods: []string{"POST", "GET"}, Headers: []v1alpha1.ValueMatch{{Name: "X-Canary"}}, Targets: target},
+		},
+	}
+	withDefault := matchesOnly
+	withDefault.Targets = target
+	routeMethods := v1alpha1.RouteSpec{URI: "a.com/x", Methods: []string{"GET"}, Targets: target}
+
+	tests := []struct {
+		name     string
+		spec     v1alpha1.RouteSpec
+		req      testRequest
+		selected int
+		err      error
+		allow    []string
+	}{
+		{name: "method of a match", spec: matchesOnly, req: testRequest{method: "HEAD"}, selected: 0},
+		{name: "methods of matches only", spec: matchesOnly, req: testRequest{method: "PUT",
+			headers: map[string]string{"X-Canary": "1"}}, selected: -1, err: ErrMethodNotAllowed,
+			allow: []string{"GET", "HEAD", "POST"}},
+		{name: "header of a match fails", spec: matchesOnly, req: testRequest{method: "PUT"},
+			selected: -1, err: ErrNoTargets, allow: []string{"GET", "HEAD", "POST"}},
+		{name: "default targets", spec: withDefault, req: testRequest{method: "PUT"}, selected: -1},
+		{name: "method of the route", spec: routeMethods, req: testRequest{method: "POST"}, selected: -1,
+			err: ErrMethodNotAllowed, allow: []string{"GET"}},
+	}
+	for _, tt := range tests {
+		rm := NewRulesManager()
+		if err := rm.AddRule(tt.spec.URI, tt.spec, Source{Name: tt.name}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		m, err := rm.Match("a.com", "/x")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		selected, err := m.Selected(tt.req)
+		if selected != tt.selected || err != tt.err {
+			t.Errorf("%s: got %d %v, want %d %v", tt.name, selected, err, tt.selected, tt.err)
+		}
+		if allow := m.AllowedMethods(); tt.allow != nil && !reflect.DeepEqual(allow, tt.allow) {
+			t.Errorf("%s: allowed %v, want %v", tt.name, allow, tt.allow)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package rulesmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+// rewriter rewrites request paths as set by RouteSpec.Rewrite.
+type rewriter struct {
+	typ string
+	// segments is the number of path segments of the uri
+	segments    int
+	prefix      string
+	re          *regexp.Regexp
+	replacement string
+}
+
+// ValidateRewrite checks the path rewrite of spec.
+func ValidateRewrite(spec *v1alpha1.RouteSpec) error {
+	_, err := compileRewrite(spec)
+	return err
+}
+
+func compileRewrite(spec *v1alpha1.RouteSpec) (*rewriter, error) {
+	rw := spec.Rewrite
+	if rw == nil {
+		return nil, nil
+	}
+
+	switch rw.Type {
+	case "", v1alpha1.RewritePass:
+		if rw.Prefix != "" || rw.Regex != "" || rw.Replacement != "" {
+			return nil, fmt.Errorf("%s rewrite takes no prefix, regex or replacement", v1alpha1.RewritePass)
+		}
+		return nil, nil
+	case v1alpha1.RewriteStripPrefix, v1alpha1.RewriteReplacePrefix:
+		if spec.MatchType == v1alpha1.MatchRegex {
+			return nil, fmt.Errorf("%s rewrite needs an exact or prefix uri", rw.Type)
+		}
+		if rw.Regex != "" || rw.Replacement != "" {
+			return nil, fmt.Errorf("%s rewrite takes no regex or replacement", rw.Type)
+		}
+		if rw.Type == v1alpha1.RewriteStripPrefix && rw.Prefix != "" {
+			return nil, fmt.Errorf("%s rewrite takes no prefix", rw.Type)
+		}
+		if rw.Type == v1alpha1.RewriteReplacePrefix && !strings.HasPrefix(rw.Prefix, "/") {
+			return nil, fmt.Errorf("prefix %q must start with /", rw.Prefix)
+		}
+		_, path := SplitURI(spec.URI)
+		return &rewriter{
+			typ:      rw.Type,
+			segments: len(splitPath(path)),
+			prefix:   strings.TrimSuffix(rw.Prefix, "/"),
+		}, nil
+	case v1alpha1.RewriteRegex:
+		if rw.Prefix != "" {
+			return nil, fmt.Errorf("%s rewrite takes no prefix", rw.Type)
+		}
+		re, err := regexp.Compile(rw.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("regex %q: %v", rw.Regex, err)
+		}
+		return &rewriter{typ: rw.Type, re: re, replacement: rw.Replacement}, nil
+	}
+	return nil, fmt.Errorf("unknown rewrite type %q", rw.Type)
+}
+
+// RewritePath returns the path to forward for a request to path.
+func (m *Match) RewritePath(path string) string {
+	rw := m.rewriter
+	if rw == nil {
+		return path
+	}
+
+	var out string
+	switch rw.typ {
+	case v1alpha1.RewriteStripPrefix:
+		out = stripSegments(path, rw.segments)
+	case v1alpha1.RewriteReplacePrefix:
+		out = rw.prefix + stripSegments(path, rw.segments)
+	case v1alpha1.RewriteRegex:
+		out = rw.re.ReplaceAllString(path, rw.replacement)
+	}
+	if !strings.HasPrefix(out, "/") {
+		out = "/" + out
+	}
+	return out
+}
+
+// stripSegments removes the first n segments from path, counted like
+// splitPath does, and returns the rest with its leading slash.
+func stripSegments(path string, n int) string {
+	for i := 0; i < n; i++ {
+		path = strings.TrimLeft(path, "/")
+		j := strings.IndexByte(path, '/')
+		if j < 0 {
+			return ""
+		}
+		path = path[j:]
+	}
+	return path
+}
@@ -0,0 +1,503 @@
+package rulesmanager
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/breaker"
+	"github.com/seveirbian/edgeserverless/pkg/hashring"
+	"github.com/seveirbian/edgeserverless/pkg/ratelimit"
+)
+
+// Match is the result of looking up a request in the rules table.
+type Match struct {
+	URI string
+	// Key is the URIKey of the rule, which identifies it along with its
+	// targets' health.
+	Key    string
+	Spec   *v1alpha1.RouteSpec
+	Source Source
+	Params map[string]string
+	// Limiter rate limits the rule, nil when it is not limited.
+	Limiter *ratelimit.Limiter
+	// Breakers are the circuit breakers of the targets, nil when the rule
+	// has none.
+	Breakers *breaker.Set
+	// RequestHeaders and ResponseHeaders change the headers of requests
+	// to targets and of their responses.
+	RequestHeaders  *Headers
+	ResponseHeaders *Headers
+
+	matchers []matcher
+	rewriter *rewriter
+	rings    []*hashring.Ring
+}
+
+// router indexes rules by host and then by path segment.
+//
+// Precedence, from highest to lowest:
+//   - exact host before wildcard host, longer wildcard suffix first
+//   - within a host, an exact path before any prefix, longer prefix first
+//   - static path segments before ":param" segments
+//   - regex rules, in uri order, when nothing else matched
+type router struct {
+	hosts     map[string]*pathNode
+	wildcards map[string]*pathNode
+	regexes   []*regexRule
+	len       int
+}
+
+type regexRule struct {
+	leaf
+	re *regexp.Regexp
+}
+
+// pathNode is shared by the rules whose paths start alike, ":param"
+// segments share one child whatever their name. Names are kept by leaves.
+type pathNode struct {
+	children map[string]*pathNode
+	param    *pathNode
+
+	exact  *leaf
+	prefix *leaf
+}
+
+type leaf struct {
+	uri string
+	key string
+	// params are the names of the ":param" segments of the path of uri
+	params   []string
+	spec     *v1alpha1.RouteSpec
+	source   Source
+	limiter  *ratelimit.Limiter
+	breakers *breaker.Set
+	matchers []matcher
+	rewriter *rewriter
+	request  *Headers
+	response *Headers
+	// rings are the hash rings of the default targets and of each match
+	rings []*hashring.Ring
+}
+
+func newRouter() *router {
+	return &router{
+		hosts:     map[string]*pathNode{},
+		wildcards: map[string]*pathNode{},
+	}
+}
+
+// SplitURI splits a route uri like "*.user.com/path" into host and path.
+func SplitURI(uri string) (string, string) {
+	i := strings.Index(uri, "/")
+	if i < 0 {
+		return uri, "/"
+	}
+	return uri[:i], uri[i:]
+}
+
+// NormalizeURI returns how the router indexes uri: its host, its path
+// segments with parameters reduced to ":", and its match type, exact when
+// empty. Regex uris are indexed as written, with no host nor segments.
+func NormalizeURI(uri string, matchType string) (string, []string, string) {
+	if matchType == "" {
+		matchType = v1alpha1.MatchExact
+	}
+	if matchType == v1alpha1.MatchRegex {
+		return "", nil, matchType
+	}
+
+	host, path := SplitURI(uri)
+	segs := splitPath(path)
+	for i, seg := range segs {
+		if strings.HasPrefix(seg, ":") {
+			segs[i] = ":"
+		}
+	}
+	return host, segs, matchType
+}
+
+// URIKey returns the same key for uris the router can not tell apart, like
+// "a.com/x" and "a.com/x/", or "a.com/users/:id" and "a.com/users/:name".
+// Only one of them can be loaded, so routes claim uris by key.
+func URIKey(uri string, matchType string) string {
+	host, segs, matchType := NormalizeURI(uri, matchType)
+	if matchType == v1alpha1.MatchRegex {
+		return matchType + " " + uri
+	}
+	return matchType + " " + host + "/" + strings.Join(segs, "/")
+}
+
+// ValidateURI checks that uri can be indexed with the given match type.
+func ValidateURI(uri string, matchType string) error {
+	switch matchType {
+	case "", v1alpha1.MatchExact, v1alpha1.MatchPrefix:
+		host, path := SplitURI(uri)
+		if host == "" {
+			return fmt.Errorf("uri %q has no host", uri)
+		}
+		if strings.Contains(host[1:], "*") || (host[0] == '*' && !strings.HasPrefix(host, "*.")) {
+			return fmt.Errorf("host %q: only a leading \"*.\" wildcard is supported", host)
+		}
+		for _, seg := range splitPath(path) {
+			if seg == ":" {
+				return fmt.Errorf("path %q has an unnamed parameter", path)
+			}
+		}
+	case v1alpha1.MatchRegex:
+		if _, err := regexp.Compile(uri); err != nil {
+			return fmt.Errorf("uri %q is not a valid regex: %v", uri, err)
+		}
+	default:
+		return fmt.Errorf("unknown match type %q", matchType)
+	}
+	return nil
+}
+
+// newLeaf compiles the rule for uri, it can then be inserted into a router.
+func newLeaf(uri string, spec *v1alpha1.RouteSpec, source Source,
+	limiter *ratelimit.Limiter, breakers *breaker.Set) (*leaf, error) {
+	if err := ValidateURI(uri, spec.MatchType); err != nil {
+		return nil, err
+	}
+	matchers, err := compileMatches(spec)
+	if err != nil {
+		return nil, err
+	}
+	rewriter, err := compileRewrite(spec)
+	if err != nil {
+		return nil, err
+	}
+	request, response, err := compileHeaders(spec)
+	if err != nil {
+		return nil, err
+	}
+	l := &leaf{
+		uri:      uri,
+		key:      URIKey(uri, spec.MatchType),
+		spec:     spec,
+		source:   source,
+		limiter:  limiter,
+		breakers: breakers,
+		matchers: matchers,
+		rewriter: rewriter,
+		request:  request,
+		response: response,
+		rings:    newRings(spec),
+	}
+	if spec.MatchType != v1alpha1.MatchRegex {
+		_, path := SplitURI(uri)
+		for _, seg := range splitPath(path) {
+			if strings.HasPrefix(seg, ":") {
+				l.params = append(l.params, seg[1:])
+			}
+		}
+	}
+	return l, nil
+}
+
+// insert adds l to the router, unless the place of its uri is taken.
+func (r *router) insert(l *leaf) error {
+	uri, spec := l.uri, l.spec
+	if spec.MatchType == v1alpha1.MatchRegex {
+		r.regexes = append(r.regexes, &regexRule{
+			leaf: *l,
+			re:   regexp.MustCompile(uri),
+		})
+		sort.Slice(r.regexes, func(i, j int) bool {
+			return r.regexes[i].uri < r.regexes[j].uri
+		})
+		r.len++
+		return nil
+	}
+
+	host, path := SplitURI(uri)
+	hosts := r.hosts
+	if strings.HasPrefix(host, "*.") {
+		hosts = r.wildcards
+		host = host[2:]
+	}
+	root, ok := hosts[host]
+	if !ok {
+		root = &pathNode{}
+		hosts[host] = root
+	}
+
+	n := root
+	for _, seg := range splitPath(path) {
+		n = n.child(seg)
+	}
+	slot := &n.exact
+	if spec.MatchType == v1alpha1.MatchPrefix {
+		slot = &n.prefix
+	}
+	if *slot != nil && (*slot).uri != uri {
+		return fmt.Errorf("uri %q is equivalent to loaded uri %q", uri, (*slot).uri)
+	}
+	*slot = l
+	r.len++
+	return nil
+}
+
+// remove drops the rule at uri loaded with spec and returns it, nil when
+// there is none.
+func (r *router) remove(uri string, spec *v1alpha1.RouteSpec) *leaf {
+	if spec.MatchType == v1alpha1.MatchRegex {
+		for i, rr := range r.regexes {
+			if rr.uri == uri {
+				r.regexes = append(r.regexes[:i], r.regexes[i+1:]...)
+				r.len--
+				return &rr.leaf
+			}
+		}
+		return nil
+	}
+
+	host, path := SplitURI(uri)
+	hosts := r.hosts
+	if strings.HasPrefix(host, "*.") {
+		hosts = r.wildcards
+		host = host[2:]
+	}
+	root, ok := hosts[host]
+	if !ok {
+		return nil
+	}
+
+	segs := splitPath(path)
+	nodes := []*pathNode{root}
+	n := root
+	for _, seg := range segs {
+		n = n.lookupChild(seg)
+		if n == nil {
+			return nil
+		}
+		nodes = append(nodes, n)
+	}
+	slot := &n.exact
+	if spec.MatchType == v1alpha1.MatchPrefix {
+		slot = &n.prefix
+	}
+	l := *slot
+	if l == nil || l.uri != uri {
+		return nil
+	}
+	*slot = nil
+	r.len--
+
+	// prune branches that no longer lead to a rule
+	for i := len(segs); i > 0; i-- {
+		if !nodes[i].empty() {
+			return l
+		}
+		nodes[i-1].removeChild(segs[i-1])
+	}
+	if root.empty() {
+		delete(hosts, host)
+	}
+	return l
+}
+
+func (r *router) match(host, path string) *Match {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	segs := splitPath(path)
+
+	if root, ok := r.hosts[host]; ok {
+		if m := root.match(segs); m != nil {
+			return m
+		}
+	}
+	for suffix := host; ; {
+		i := strings.Index(suffix, ".")
+		if i < 0 {
+			break
+		}
+		suffix = suffix[i+1:]
+		if root, ok := r.wildcards[suffix]; ok {
+			if m := root.match(segs); m != nil {
+				return m
+			}
+		}
+	}
+
+	full := host + path
+	for _, rr := range r.regexes {
+		sub := rr.re.FindStringSubmatch(full)
+		if sub == nil {
+			continue
+		}
+		m := rr.toMatch()
+		for i, name := range rr.re.SubexpNames() {
+			if i > 0 && name != "" {
+				if m.Params == nil {
+					m.Params = map[string]string{}
+				}
+				m.Params[name] = sub[i]
+			}
+		}
+		return m
+	}
+
+	return nil
+}
+
+// each calls fn for every rule in the router.
+func (r *router) each(fn func(l *leaf)) {
+	for _, root := range r.hosts {
+		root.each(fn)
+	}
+	for _, root := range r.wildcards {
+		root.each(fn)
+	}
+	for _, rr := range r.regexes {
+		fn(&rr.leaf)
+	}
+}
+
+func (n *pathNode) each(fn func(l *leaf)) {
+	if n.exact != nil {
+		fn(n.exact)
+	}
+	if n.prefix != nil {
+		fn(n.prefix)
+	}
+	for _, c := range n.children {
+		c.each(fn)
+	}
+	if n.param != nil {
+		n.param.each(fn)
+	}
+}
+
+func (n *pathNode) child(seg string) *pathNode {
+	if strings.HasPrefix(seg, ":") {
+		if n.param == nil {
+			n.param = &pathNode{}
+		}
+		return n.param
+	}
+	if n.children == nil {
+		n.children = map[string]*pathNode{}
+	}
+	c, ok := n.children[seg]
+	if !ok {
+		c = &pathNode{}
+		n.children[seg] = c
+	}
+	return c
+}
+
+func (n *pathNode) lookupChild(seg string) *pathNode {
+	if strings.HasPrefix(seg, ":") {
+		return n.param
+	}
+	return n.children[seg]
+}
+
+func (n *pathNode) removeChild(seg string) {
+	if strings.HasPrefix(seg, ":") {
+		n.param = nil
+		return
+	}
+	delete(n.children, seg)
+}
+
+func (n *pathNode) empty() bool {
+	return n.exact == nil && n.prefix == nil && n.param == nil && len(n.children) == 0
+}
+
+// match walks segs from n and returns the best rule, preferring an exact
+// match over the longest prefix, and static segments over parameters.
+func (n *pathNode) match(segs []string) *Match {
+	var best *Match
+	bestDepth := -1
+	var params []string
+
+	var walk func(n *pathNode, depth int) bool
+	walk = func(n *pathNode, depth int) bool {
+		if depth == len(segs) && n.exact != nil {
+			best = n.exact.toMatch(params...)
+			return true
+		}
+		if n.prefix != nil && depth > bestDepth {
+			best = n.prefix.toMatch(params...)
+			bestDepth = depth
+		}
+		if depth == len(segs) {
+			return false
+		}
+		if c, ok := n.children[segs[depth]]; ok && walk(c, depth+1) {
+			return true
+		}
+		if n.param != nil {
+			params = append(params, segs[depth])
+			if walk(n.param, depth+1) {
+				return true
+			}
+			params = params[:len(params)-1]
+		}
+		return false
+	}
+	walk(n, 0)
+
+	return best
+}
+
+// toMatch builds a Match for l from the values of its parameters, in path
+// order.
+func (l *leaf) toMatch(params ...string) *Match {
+	m := &Match{
+		URI:             l.uri,
+		Key:             l.key,
+		Spec:            l.spec,
+		Source:          l.source,
+		Limiter:         l.limiter,
+		Breakers:        l.breakers,
+		RequestHeaders:  l.request,
+		ResponseHeaders: l.response,
+		matchers:        l.matchers,
+		rewriter:        l.rewriter,
+		rings:           l.rings,
+	}
+	if len(params) > 0 {
+		m.Params = make(map[string]string, len(params))
+		for i, value := range params {
+			m.Params[l.params[i]] = value
+		}
+	}
+	return m
+}
+
+// newRings returns the hash rings of the default targets and of each match
+// of spec, nil unless it has a session affinity key.
+func newRings(spec *v1alpha1.RouteSpec) []*hashring.Ring {
+	if spec.SessionAffinity == nil || spec.SessionAffinity.Key == "" {
+		return nil
+	}
+	rings := []*hashring.Ring{hashring.New(spec.Targets)}
+	for _, m := range spec.Matches {
+		rings = append(rings, hashring.New(m.Targets))
+	}
+	return rings
+}
+
+// splitPath returns the non-empty segments of path, so "/a/b/" and "/a//b"
+// both become [a b].
+func splitPath(path string) []string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	segs := strings.Split(path, "/")
+	out := segs[:0]
+	for _, s := range segs {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,230 @@
+package rulesmanager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+)
+
+// addRules loads a rule for each uri, of matchType, into a new RulesManager.
+func addRules(t *testing.T, matchType string, uris ...string) *RulesManager {
+	rm := NewRulesManager()
+	for _, uri := range uris {
+		spec := v1alpha1.RouteSpec{
+			URI:       uri,
+			MatchType: matchType,
+			Targets:   []v1alpha1.RouteTarget{{Target: uri, Type: "k8sservice", Ratio: 1}},
+		}
+		if err := rm.AddRule(uri, spec, Source{Name: uri}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rm
+}
+
+// TestRouterParamNames checks that rules sharing a ":param" segment keep
+// their own parameter names.
+func TestRouterParamNames(t *testing.T) {
+	rm := addRules(t, v1alpha1.MatchExact, "a.com/users/:id", "a.com/users/:name/x", "a.com/users/:uid/y/:tag")
+
+	tests := []struct {
+		path   string
+		uri    string
+		params map[string]string
+	}{
+		{"/users/7", "a.com/users/:id", map[string]string{"id": "7"}},
+		{"/users/7/x", "a.com/users/:name/x", map[string]string{"name": "7"}},
+		{"/users/7/y/new", "a.com/users/:uid/y/:tag", map[string]string{"uid": "7", "tag": "new"}},
+	}
+	for _, tt := range tests {
+		m, err := rm.Match("a.com", tt.path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if m.URI != tt.uri || !reflect.DeepEqual(m.Params, tt.params) {
+			t.Errorf("%s: got %s %v, want %s %v", tt.path, m.URI, m.Params, tt.uri, tt.params)
+		}
+	}
+}
+
+// TestRouterPrecedence checks which rule serves a request matched by
+// several rules.
+func TestRouterPrecedence(t *testing.T) {
+	rm := NewRulesManager()
+	for uri, matchType := range map[string]string{
+		"a.com/api/users":   v1alpha1.MatchExact,
+		"a.com/api/:id":     v1alpha1.MatchExact,
+		"a.com/api":         v1alpha1.MatchPrefix,
+		"a.com/api/v1":      v1alpha1.MatchPrefix,
+		"*.a.com/api/users": v1alpha1.MatchExact,
+		"*.b.a.com/api":     v1alpha1.MatchPrefix,
+		"y.a.com/api":       v1alpha1.MatchPrefix,
+		"c.com/x":           v1alpha1.MatchExact,
+		`c\.com/.*`:         v1alpha1.MatchRegex,
+	} {
+		spec := v1alpha1.RouteSpec{
+			URI:       uri,
+			MatchType: matchType,
+			Targets:   []v1alpha1.RouteTarget{{Target: uri, Type: "k8sservice", Ratio: 1}},
+		}
+		if err := rm.AddRule(uri, spec, Source{Name: uri}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		host string
+		path string
+		want string
+	}{
+		{"exact path before prefix and param", "a.com", "/api/users", "a.com/api/users"},
+		{"param before prefix", "a.com", "/api/7", "a.com/api/:id"},
+		{"longest prefix", "a.com", "/api/v1/users", "a.com/api/v1"},
+		{"prefix past a param", "a.com", "/api/7/x", "a.com/api"},
+		{"wildcard host", "x.a.com", "/api/users", "*.a.com/api/users"},
+		{"longer wildcard suffix", "x.b.a.com", "/api/users", "*.b.a.com/api"},
+		{"exact host before wildcard", "y.a.com", "/api/users", "y.a.com/api"},
+		{"host rule before regex", "c.com", "/x", "c.com/x"},
+		{"regex when nothing else matches", "c.com", "/y", `c\.com/.*`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := rm.Match(tt.host, tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.URI != tt.want {
+				t.Errorf("%s%s: got %s, want %s", tt.host, tt.path, m.URI, tt.want)
+			}
+		})
+	}
+}
+
+// TestSameURIOtherMatchType checks that rules with the same uri and other
+// match types are loaded, served and deleted independently.
+func TestSameURIOtherMatchType(t *testing.T) {
+	rm := NewRulesManager()
+	for _, matchType := range []string{v1alpha1.MatchExact, v1alpha1.MatchPrefix, v1alpha1.MatchRegex} {
+		spec := v1alpha1.RouteSpec{
+			URI:       "a.com/x",
+			MatchType: matchType,
+			Targets:   []v1alpha1.RouteTarget{{Target: matchType, Type: "k8sservice", Ratio: 1}},
+			RateLimit: &v1alpha1.RateLimit{RequestsPerSecond: 1},
+		}
+		if err := rm.AddRule(spec.URI, spec, Source{Name: matchType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(rm.List()); n != 3 {
+		t.Fatalf("%d rules listed, want 3", n)
+	}
+
+	tests := []struct {
+		name   string
+		delete string
+		// want maps request paths to the match type of the rule serving
+		// them, "" when none
+		want map[string]string
+	}{
+		{name: "all loaded", want: map[string]string{"/x": v1alpha1.MatchExact, "/x/y": v1alpha1.MatchPrefix, "/xy": v1alpha1.MatchRegex}},
+		{name: "exact deleted", delete: v1alpha1.MatchExact, want: map[string]string{"/x": v1alpha1.MatchPrefix, "/xy": v1alpha1.MatchRegex}},
+		{name: "prefix deleted", delete: v1alpha1.MatchPrefix, want: map[string]string{"/x": v1alpha1.MatchRegex, "/x/y": v1alpha1.MatchRegex}},
+		{name: "regex deleted", delete: v1alpha1.MatchRegex, want: map[string]string{"/x": "", "/xy": ""}},
+	}
+	for _, tt := range tests {
+		if tt.delete != "" {
+			rm.DeleteRule("a.com/x", tt.delete)
+			if _, err := rm.GetRule("a.com/x", tt.delete); err == nil {
+				t.Errorf("%s: GetRule still finds the rule", tt.name)
+			}
+		}
+		for path, want := range tt.want {
+			got := ""
+			if m, err := rm.Match("a.com", path); err == nil {
+				got = m.Spec.MatchType
+				if m.Limiter == nil {
+					t.Errorf("%s: %s served without the rate limiter of its rule", tt.name, path)
+				}
+			}
+			if got != want {
+				t.Errorf("%s: %s served by %q rule, want %q", tt.name, path, got, want)
+			}
+		}
+	}
+}
+
+// TestURIKey checks which uris claim the same place in the router.
+func TestURIKey(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		typeA string
+		typeB string
+		same  bool
+	}{
+		{a: "a.com/x", b: "a.com/x/", same: true},
+		{a: "a.com/x", b: "a.com//x", same: true},
+		{a: "a.com", b: "a.com/", same: true},
+		{a: "a.com/x", b: "a.com/x", typeB: v1alpha1.MatchExact, same: true},
+		{a: "a.com/users/:id", b: "a.com/users/:name", same: true},
+		{a: "a.com/users/:id", b: "a.com/users/id"},
+		{a: "a.com/x", b: "a.com/x", typeB: v1alpha1.MatchPrefix},
+		{a: "a.com/x", b: "*.a.com/x"},
+		{a: "a.com/x", b: "b.com/x"},
+		{a: "a.com/x", b: "a.com/x/", typeA: v1alpha1.MatchRegex, typeB: v1alpha1.MatchRegex},
+	}
+	for _, tt := range tests {
+		ka, kb := URIKey(tt.a, tt.typeA), URIKey(tt.b, tt.typeB)
+		if (ka == kb) != tt.same {
+			t.Errorf("%s %q and %s %q: keys %q and %q, want same %v", tt.typeA, tt.a, tt.typeB, tt.b, ka, kb, tt.same)
+		}
+	}
+}
+
+// TestAddRuleRejectedUpdate checks that an update that can not be loaded
+// leaves the rule loaded before serving, with its rate limiter.
+func TestAddRuleRejectedUpdate(t *testing.T) {
+	rm := addRules(t, v1alpha1.MatchPrefix, "a.com/x/")
+	spec := v1alpha1.RouteSpec{
+		URI:       "a.com/x",
+		Targets:   []v1alpha1.RouteTarget{{Target: "t", Type: "k8sservice", Ratio: 1}},
+		RateLimit: &v1alpha1.RateLimit{RequestsPerSecond: 1, Burst: 1},
+	}
+	if err := rm.AddRule(spec.URI, spec, Source{Name: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := rm.Match("a.com", "/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	badMatch := spec
+	badMatch.Matches = []v1alpha1.RouteMatch{{
+		Headers: []v1alpha1.ValueMatch{{Name: "X", Regex: "("}},
+		Targets: spec.Targets,
+	}}
+	badRewrite := spec
+	badRewrite.Rewrite = &v1alpha1.PathRewrite{Type: v1alpha1.RewriteReplacePrefix, Prefix: "x"}
+	for name, update := range map[string]v1alpha1.RouteSpec{"bad match": badMatch, "bad rewrite": badRewrite} {
+		update.RateLimit = &v1alpha1.RateLimit{RequestsPerSecond: 2, Burst: 2}
+		if err := rm.AddRule(update.URI, update, Source{Name: "x"}); err == nil {
+			t.Fatalf("%s update loaded", name)
+		}
+		after, err := rm.Match("a.com", "/x")
+		if err != nil {
+			t.Fatalf("%s update: %v", name, err)
+		}
+		if after.URI != spec.URI || after.Spec.MatchType != "" || after.Limiter != before.Limiter {
+			t.Errorf("%s update: got rule %s %q, limiter kept %v", name, after.URI,
+				after.Spec.MatchType, after.Limiter == before.Limiter)
+		}
+		if got, err := rm.GetRule(spec.URI, spec.MatchType); err != nil || got.RateLimit.RequestsPerSecond != 1 {
+			t.Errorf("%s update: GetRule got %v %v", name, got, err)
+		}
+	}
+	if n := rm.Len(); n != 2 {
+		t.Errorf("%d rules loaded, want 2", n)
+	}
+}
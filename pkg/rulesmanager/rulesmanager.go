@@ -3,22 +3,60 @@ package rulesmanager
 import (
 	"fmt"
 	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/breaker"
+	"github.com/seveirbian/edgeserverless/pkg/ratelimit"
+	"sort"
 	"sync"
 )
 
 type RulesManager struct {
-	// uri -> targets
+	// URIKey of the uri and match type -> targets
 	Rules sync.Map
+
+	mu       sync.RWMutex
+	router   *router
+	limiters map[string]*limiter
+	breakers map[string]*breaker.Set
+}
+
+// limiter is the rate limiter of a rule and the settings it was made with.
+type limiter struct {
+	config  v1alpha1.RateLimit
+	limiter *ratelimit.Limiter
+}
+
+// Source identifies the Route a rule was loaded from.
+type Source struct {
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// Rule is a rule loaded into the rules table.
+type Rule struct {
+	URI string `json:"uri"`
+	// Key is the URIKey of the rule, rules with the same uri and another
+	// match type are loaded side by side.
+	Key    string              `json:"-"`
+	Spec   *v1alpha1.RouteSpec `json:"spec"`
+	Source Source              `json:"source"`
+	// Breakers are the circuit breakers of the targets, nil when the route
+	// has none.
+	Breakers *breaker.Set `json:"-"`
 }
 
 func NewRulesManager() *RulesManager {
 	return &RulesManager{
-		Rules: sync.Map{},
+		Rules:    sync.Map{},
+		router:   newRouter(),
+		limiters: map[string]*limiter{},
+		breakers: map[string]*breaker.Set{},
 	}
 }
 
-func (r *RulesManager) GetRule(uri string) (*v1alpha1.RouteSpec, error) {
-	values, ok := r.Rules.Load(uri)
+// GetRule returns the spec of the rule at uri of matchType.
+func (r *RulesManager) GetRule(uri string, matchType string) (*v1alpha1.RouteSpec, error) {
+	values, ok := r.Rules.Load(URIKey(uri, matchType))
 	if !ok {
 		return nil, fmt.Errorf("[RulesManager] no value for uri %s\n", uri)
 	}
@@ -31,10 +69,140 @@ func (r *RulesManager) GetRule(uri string) (*v1alpha1.RouteSpec, error) {
 	return &targets, nil
 }
 
-func (r *RulesManager) AddRule(uri string, targets v1alpha1.RouteSpec) {
-	r.Rules.Store(uri, targets)
+// Match finds the rule serving a request for host and path.
+func (r *RulesManager) Match(host, path string) (*Match, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m := r.router.match(host, path)
+	if m == nil {
+		return nil, fmt.Errorf("[RulesManager] no rule matches %s%s\n", host, path)
+	}
+
+	return m, nil
+}
+
+// AddRule loads the rule at uri of the match type of targets, or updates
+// the one with the same URIKey. A rule that can not be loaded leaves the one
+// loaded before, if any, serving.
+func (r *RulesManager) AddRule(uri string, targets v1alpha1.RouteSpec, source Source) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := URIKey(uri, targets.MatchType)
+	limiter, breakers := r.limiter(key, targets.RateLimit), r.breakerSet(key, uri, &targets)
+	l, err := newLeaf(uri, &targets, source, limiter.get(), breakers)
+	if err != nil {
+		return fmt.Errorf("[RulesManager] add rule %s error: %v\n", uri, err)
+	}
+
+	var old *leaf
+	if value, ok := r.Rules.Load(key); ok {
+		oldSpec := value.(v1alpha1.RouteSpec)
+		old = r.router.remove(oldSpec.URI, &oldSpec)
+	}
+	if err := r.router.insert(l); err != nil {
+		if old != nil {
+			r.router.insert(old)
+		}
+		return fmt.Errorf("[RulesManager] add rule %s error: %v\n", uri, err)
+	}
+	r.Rules.Store(key, targets)
+
+	if limiter == nil {
+		delete(r.limiters, key)
+	} else {
+		r.limiters[key] = limiter
+	}
+	if breakers == nil {
+		delete(r.breakers, key)
+	} else {
+		breakers.Retain(targets.AllTargets())
+		r.breakers[key] = breakers
+	}
+
+	return nil
+}
+
+// Len returns the number of rules loaded.
+func (r *RulesManager) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.router.len
+}
+
+// List returns the loaded rules ordered by uri.
+func (r *RulesManager) List() []Rule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rules := make([]Rule, 0, r.router.len)
+	r.router.each(func(l *leaf) {
+		rules = append(rules, Rule{URI: l.uri, Key: l.key, Spec: l.spec, Source: l.source, Breakers: l.breakers})
+	})
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].URI != rules[j].URI {
+			return rules[i].URI < rules[j].URI
+		}
+		return rules[i].Key < rules[j].Key
+	})
+
+	return rules
+}
+
+// DeleteRule drops the rule at uri of matchType, leaving rules at uri of
+// other match types loaded.
+func (r *RulesManager) DeleteRule(uri string, matchType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := URIKey(uri, matchType)
+	if old, ok := r.Rules.Load(key); ok {
+		oldSpec := old.(v1alpha1.RouteSpec)
+		r.router.remove(oldSpec.URI, &oldSpec)
+	}
+	r.Rules.Delete(key)
+	delete(r.limiters, key)
+	delete(r.breakers, key)
+}
+
+// limiter returns the rate limiter for the rule with key, nil when rl is.
+// The one in use is kept across updates leaving rl unchanged, so they do not
+// refill buckets. Must be called with mu held.
+func (r *RulesManager) limiter(key string, rl *v1alpha1.RateLimit) *limiter {
+	if rl == nil {
+		return nil
+	}
+	if l, ok := r.limiters[key]; ok && l.config == *rl {
+		return l
+	}
+
+	return &limiter{
+		config:  *rl,
+		limiter: ratelimit.New(float64(rl.RequestsPerSecond), int(rl.Burst)),
+	}
 }
 
-func (r *RulesManager) DeleteRule(uri string) {
-	r.Rules.Delete(uri)
+func (l *limiter) get() *ratelimit.Limiter {
+	if l == nil {
+		return nil
+	}
+	return l.limiter
+}
+
+// breakerSet returns the circuit breakers for the rule with key at uri, nil
+// when spec has none. Like limiters, they are kept across updates leaving the
+// circuit breaker unchanged. Must be called with mu held.
+func (r *RulesManager) breakerSet(key string, uri string, spec *v1alpha1.RouteSpec) *breaker.Set {
+	if spec.CircuitBreaker == nil {
+		return nil
+	}
+	config := breaker.NewConfig(spec.CircuitBreaker)
+	if set, ok := r.breakers[key]; ok && set.Config() == config {
+		return set
+	}
+
+	_, _, matchType := NormalizeURI(uri, spec.MatchType)
+	return breaker.NewSet(uri, matchType, config)
 }
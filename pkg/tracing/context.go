@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Headers of the W3C trace context.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether sc has non-zero trace and span ids.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats sc as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// Extract parses the traceparent and tracestate headers of a request. It
+// returns an invalid SpanContext if traceparent is missing or malformed.
+func Extract(traceparent string, tracestate string) SpanContext {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}
+	}
+	// version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}
+	}
+	if !decode(sc.TraceID[:], parts[1]) || !decode(sc.SpanID[:], parts[2]) {
+		return SpanContext{}
+	}
+	var flags [1]byte
+	if !decode(flags[:], parts[3]) {
+		return SpanContext{}
+	}
+	if !sc.IsValid() {
+		return SpanContext{}
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+	sc.TraceState = tracestate
+	return sc
+}
+
+func decode(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
@@ -0,0 +1,249 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// exporter sends finished spans in batches to an OTLP/HTTP collector using
+// the JSON encoding. Spans are dropped when the queue is full or the exporter
+// is shut down.
+type exporter struct {
+	config Config
+	client *http.Client
+
+	// spans is never closed, requests still in flight after a shutdown may
+	// end their spans
+	spans chan *Span
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newExporter(config Config) *exporter {
+	e := &exporter{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		spans:  make(chan *Span, 4*config.BatchSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *exporter) export(s *Span) {
+	select {
+	case <-e.stop:
+		return
+	default:
+	}
+
+	select {
+	case e.spans <- s:
+	default:
+	}
+}
+
+// shutdown exports the queued spans and stops the exporter.
+func (e *exporter) shutdown() {
+	e.once.Do(func() {
+		close(e.stop)
+		<-e.done
+	})
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, e.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			fmt.Printf("[tracing] export %d spans: %v\n", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	add := func(s *Span) {
+		batch = append(batch, s)
+		if len(batch) >= e.config.BatchSize {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case s := <-e.spans:
+			add(s)
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case s := <-e.spans:
+					add(s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) send(batch []*Span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, toOTLP(s))
+	}
+
+	body, err := json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{toAttribute("service.name", e.config.ServiceName)},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/seveirbian/edgeserverless/pkg/tracing"},
+				Spans: spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := e.client.Post(e.config.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", res.Status)
+	}
+	return nil
+}
+
+// The types below follow the JSON encoding of the OTLP trace service
+// request, ids are hex and 64 bit integers strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// Status codes as in OTLP.
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func toOTLP(s *Span) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+		TraceState:        s.context.TraceState,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusUnset},
+	}
+	if s.parent != (SpanID{}) {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for _, a := range s.attributes {
+		span.Attributes = append(span.Attributes, toAttribute(a.key, a.value))
+	}
+	if s.failed {
+		span.Status = otlpStatus{Code: statusError, Message: s.err}
+	}
+	return span
+}
+
+func toAttribute(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case bool:
+		v.BoolValue = &value
+	case int:
+		i := strconv.Itoa(value)
+		v.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(value, 10)
+		v.IntValue = &i
+	case float64:
+		v.DoubleValue = &value
+	default:
+		str := fmt.Sprint(value)
+		v.StringValue = &str
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,186 @@
+package tracing
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// Span kinds, numbered as in OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Config configures a Tracer.
+type Config struct {
+	ServiceName string
+	// Endpoint is the OTLP/HTTP traces url of a collector, like
+	// http://127.0.0.1:4318/v1/traces.
+	Endpoint string
+	// SampleRate is the fraction of new traces sampled, in [0, 1]. Traces
+	// started upstream follow the sampled flag of their traceparent.
+	SampleRate float64
+	// FlushInterval is how often finished spans are exported.
+	FlushInterval time.Duration
+	// BatchSize is the most spans sent in one export request.
+	BatchSize int
+}
+
+// Tracer starts spans and exports the sampled ones. A nil Tracer starts nil
+// spans, which are valid and do nothing.
+type Tracer struct {
+	config   Config
+	exporter *exporter
+}
+
+func NewTracer(config Config) (*Tracer, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("[tracing] no OTLP endpoint")
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, fmt.Errorf("[tracing] sample rate %v is not in [0, 1]", config.SampleRate)
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+
+	return &Tracer{
+		config:   config,
+		exporter: newExporter(config),
+	}, nil
+}
+
+// Start starts a span. It continues the trace of parent when parent is valid
+// and starts a new one otherwise.
+func (t *Tracer) Start(parent SpanContext, name string, kind SpanKind) *Span {
+	if t == nil {
+		return nil
+	}
+
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+	if parent.IsValid() {
+		s.parent = parent.SpanID
+		s.context = SpanContext{
+			TraceID:    parent.TraceID,
+			Sampled:    parent.Sampled,
+			TraceState: parent.TraceState,
+		}
+	} else {
+		s.context = SpanContext{
+			TraceID: newTraceID(),
+			Sampled: rand.Float64() < t.config.SampleRate,
+		}
+	}
+	s.context.SpanID = newSpanID()
+
+	return s
+}
+
+// Shutdown exports the spans still queued.
+func (t *Tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	t.exporter.shutdown()
+}
+
+// Span is one timed operation of a trace. All methods are safe on a nil Span.
+type Span struct {
+	tracer *Tracer
+
+	mu         sync.Mutex
+	context    SpanContext
+	parent     SpanID
+	name       string
+	kind       SpanKind
+	start      time.Time
+	end        time.Time
+	attributes []attribute
+	err        string
+	failed     bool
+	ended      bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Child starts a span of the same trace with s as parent.
+func (s *Span) Child(name string, kind SpanKind) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(s.Context(), name, kind)
+}
+
+// Context returns the span context of s, to be propagated to the upstream.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute sets an attribute of s. value is a string, bool, int, int64
+// or float64.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.attributes {
+		if s.attributes[i].key == key {
+			s.attributes[i].value = value
+			return
+		}
+	}
+	s.attributes = append(s.attributes, attribute{key: key, value: value})
+}
+
+// SetError marks s as failed with err. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failed = true
+	s.err = err.Error()
+}
+
+// End ends s and queues it for export if it is sampled. Later calls do
+// nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.exporter.export(s)
+	}
+}
@@ -0,0 +1,33 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestShutdown checks that Shutdown exports the queued spans and that spans
+// ended later, by requests still in flight, are dropped.
+func TestShutdown(t *testing.T) {
+	var exports int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&exports, 1)
+	}))
+	defer collector.Close()
+
+	tracer, err := NewTracer(Config{Endpoint: collector.URL, SampleRate: 1, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Start(SpanContext{}, "queued", SpanKindServer).End()
+	late := tracer.Start(SpanContext{}, "late", SpanKindServer)
+
+	tracer.Shutdown()
+	if got := atomic.LoadInt32(&exports); got != 1 {
+		t.Errorf("got %d exports on shutdown, want 1", got)
+	}
+	late.End()
+	tracer.Shutdown()
+}
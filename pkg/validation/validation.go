@@ -0,0 +1,280 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+	"github.com/seveirbian/edgeserverless/pkg/rulesmanager"
+)
+
+// MaxRatio is the upper bound for the sum of ratios in one target set.
+const MaxRatio = 100
+
+// ValidateRouteSpec checks everything about spec that can be decided without
+// looking at other routes. It is shared by the controller and route-webhook.
+func ValidateRouteSpec(spec *v1alpha1.RouteSpec) error {
+	if spec.URI == "" {
+		return fmt.Errorf("uri must not be empty")
+	}
+	if err := rulesmanager.ValidateURI(spec.URI, spec.MatchType); err != nil {
+		return err
+	}
+	if err := rulesmanager.ValidateMatches(spec); err != nil {
+		return err
+	}
+	if err := rulesmanager.ValidateRewrite(spec); err != nil {
+		return fmt.Errorf("rewrite: %v", err)
+	}
+	if err := rulesmanager.ValidateHeaders(spec); err != nil {
+		return fmt.Errorf("headers: %v", err)
+	}
+	if len(spec.Targets) == 0 && len(spec.Matches) == 0 {
+		return fmt.Errorf("at least one target is required")
+	}
+	if err := ValidateTargets(spec.Targets); err != nil {
+		return fmt.Errorf("targets: %v", err)
+	}
+	for i, m := range spec.Matches {
+		if err := ValidateTargets(m.Targets); err != nil {
+			return fmt.Errorf("matches[%d].targets: %v", i, err)
+		}
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"timeout":     spec.Timeout,
+		"idleTimeout": spec.IdleTimeout,
+	} {
+		if d != nil && d.Duration <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	listeners := map[string]bool{}
+	for _, l := range spec.Listeners {
+		if l == "" || listeners[l] {
+			return fmt.Errorf("listeners must be unique and not empty")
+		}
+		listeners[l] = true
+	}
+	if spec.TLS != nil && spec.TLS.SecretName == "" {
+		return fmt.Errorf("tls: secretName must not be empty")
+	}
+	if err := ValidateRetryPolicy(spec.Retry); err != nil {
+		return fmt.Errorf("retry: %v", err)
+	}
+	if err := ValidateSessionAffinity(spec.SessionAffinity); err != nil {
+		return fmt.Errorf("sessionAffinity: %v", err)
+	}
+	if err := ValidateRateLimit(spec.RateLimit); err != nil {
+		return fmt.Errorf("rateLimit: %v", err)
+	}
+	if err := ValidateCircuitBreaker(spec.CircuitBreaker); err != nil {
+		return fmt.Errorf("circuitBreaker: %v", err)
+	}
+	if err := ValidateHealthCheck(spec.HealthCheck); err != nil {
+		return fmt.Errorf("healthCheck: %v", err)
+	}
+	return nil
+}
+
+// MaxAttempts bounds RetryPolicy.Attempts.
+const MaxAttempts = 10
+
+var retryOn = map[string]bool{
+	v1alpha1.RetryOnConnectFailure: true,
+	v1alpha1.RetryOnTimeout:        true,
+	v1alpha1.RetryOn5xx:            true,
+	v1alpha1.RetryOn429:            true,
+}
+
+func ValidateRetryPolicy(policy *v1alpha1.RetryPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.Attempts < 1 || policy.Attempts > MaxAttempts {
+		return fmt.Errorf("attempts must be between 1 and %d", MaxAttempts)
+	}
+	for _, on := range policy.RetryOn {
+		if !retryOn[on] {
+			return fmt.Errorf("unknown retryOn condition %q", on)
+		}
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"perTryTimeout": policy.PerTryTimeout,
+		"backoff":       policy.Backoff,
+		"maxBackoff":    policy.MaxBackoff,
+	} {
+		if d != nil && d.Duration < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+func ValidateSessionAffinity(sa *v1alpha1.SessionAffinity) error {
+	if sa == nil {
+		return nil
+	}
+	switch sa.Key {
+	case "", v1alpha1.AffinityByClientIP:
+		if sa.Name != "" {
+			return fmt.Errorf("name is only used with the header, cookie and query keys")
+		}
+	case v1alpha1.AffinityByHeader, v1alpha1.AffinityByCookie, v1alpha1.AffinityByQuery:
+		if sa.Name == "" {
+			return fmt.Errorf("name is required with the %s key", sa.Key)
+		}
+	default:
+		return fmt.Errorf("unknown key %q", sa.Key)
+	}
+	if sa.Key == "" && sa.Cookie == nil {
+		return fmt.Errorf("at least one of key and cookie is required")
+	}
+	if c := sa.Cookie; c != nil {
+		if c.Name == "" || strings.ContainsAny(c.Name, " \t\r\n\"(),/:;<=>?@[\\]{}") {
+			return fmt.Errorf("cookie name %q is not valid", c.Name)
+		}
+		if c.TTL != nil && c.TTL.Duration < 0 {
+			return fmt.Errorf("cookie ttl must not be negative")
+		}
+		if c.Path != "" && c.Path[0] != '/' {
+			return fmt.Errorf("cookie path %q must start with /", c.Path)
+		}
+	}
+	return nil
+}
+
+func ValidateRateLimit(rl *v1alpha1.RateLimit) error {
+	if rl == nil {
+		return nil
+	}
+	if rl.RequestsPerSecond < 1 {
+		return fmt.Errorf("requestsPerSecond must be positive")
+	}
+	if rl.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	switch rl.Key {
+	case "", v1alpha1.RateLimitByRoute, v1alpha1.RateLimitByClientIP:
+		if rl.Name != "" {
+			return fmt.Errorf("name is only used with the header and jwtClaim keys")
+		}
+	case v1alpha1.RateLimitByHeader, v1alpha1.RateLimitByJWTClaim:
+		if rl.Name == "" {
+			return fmt.Errorf("name is required with the %s key", rl.Key)
+		}
+	default:
+		return fmt.Errorf("unknown key %q", rl.Key)
+	}
+	return nil
+}
+
+func ValidateCircuitBreaker(cb *v1alpha1.CircuitBreaker) error {
+	if cb == nil {
+		return nil
+	}
+	if cb.MaxRequests < 0 || cb.MaxPending < 0 || cb.MinRequests < 0 || cb.HalfOpenRequests < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if cb.MaxPending > 0 && cb.MaxRequests == 0 {
+		return fmt.Errorf("maxPending requires maxRequests")
+	}
+	if cb.ErrorRate < 0 || cb.ErrorRate > 100 {
+		return fmt.Errorf("errorRate must be between 0 and 100")
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"pendingTimeout": cb.PendingTimeout,
+		"slowRequest":    cb.SlowRequest,
+		"window":         cb.Window,
+		"openTime":       cb.OpenTime,
+	} {
+		if d != nil && d.Duration < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// ValidateTargets checks each target against its backend, when that backend
+// is configured, and that the ratios of a non-empty set sum to between 1 and
+// MaxRatio.
+func ValidateTargets(targets []v1alpha1.RouteTarget) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	var total int64
+	for i, t := range targets {
+		if t.Target == "" {
+			return fmt.Errorf("[%d]: target must not be empty", i)
+		}
+		if t.Ratio < 0 {
+			return fmt.Errorf("[%d]: ratio must not be negative", i)
+		}
+		if bke, err := backend.GetBackend(t.Type); err == nil {
+			if v, ok := bke.(backend.TargetValidator); ok {
+				if err := v.ValidateTarget(t.Target); err != nil {
+					return fmt.Errorf("[%d]: %v", i, err)
+				}
+			}
+		}
+		total += t.Ratio
+	}
+
+	if total <= 0 || total > MaxRatio {
+		return fmt.Errorf("ratios sum to %d, must be between 1 and %d", total, MaxRatio)
+	}
+	return nil
+}
+
+func ValidateHealthCheck(hc *v1alpha1.HealthCheck) error {
+	if hc == nil {
+		return nil
+	}
+	if hc.Path != "" && hc.Path[0] != '/' {
+		return fmt.Errorf("path %q must start with /", hc.Path)
+	}
+	if hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 || hc.ConsecutiveFailures < 0 {
+		return fmt.Errorf("thresholds must not be negative")
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"interval":     hc.Interval,
+		"timeout":      hc.Timeout,
+		"ejectionTime": hc.EjectionTime,
+	} {
+		if d != nil && d.Duration < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// ValidateBackends returns an error when a target of spec refers to a
+// backend that is not configured.
+func ValidateBackends(spec *v1alpha1.RouteSpec) error {
+	for _, t := range spec.AllTargets() {
+		if _, err := backend.GetBackend(t.Type); err != nil {
+			return fmt.Errorf("backend %q of target %q is not configured, known backends: %v",
+				t.Type, t.Target, backend.Names())
+		}
+	}
+	return nil
+}
+
+// ValidateURIUnclaimed returns an error when a route other than route
+// already claims its uri, or one the router can not tell apart from it.
+func ValidateURIUnclaimed(route *v1alpha1.Route, routes []*v1alpha1.Route) error {
+	key := rulesmanager.URIKey(route.Spec.URI, route.Spec.MatchType)
+	for _, r := range routes {
+		if r.Namespace == route.Namespace && r.Name == route.Name {
+			continue
+		}
+		if r.DeletionTimestamp == nil && rulesmanager.URIKey(r.Spec.URI, r.Spec.MatchType) == key {
+			return fmt.Errorf("uri %q is already claimed by route %s/%s with uri %q",
+				route.Spec.URI, r.Namespace, r.Name, r.Spec.URI)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/backend"
+)
+
+// TestValidateYuanrongTargets checks the function urns accepted as
+// yuanrong targets.
+func TestValidateYuanrongTargets(t *testing.T) {
+	if _, err := backend.GetBackend(backend.YuanrongBackendType); err != nil {
+		err := backend.NewBackend(backend.Config{
+			Name:      backend.YuanrongBackendType,
+			Type:      backend.YuanrongBackendType,
+			Endpoints: []string{"127.0.0.1:11111"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		target string
+		valid  bool
+	}{
+		{target: "sn:cn:yrk:12345678:function:0-default-hello:$latest", valid: true},
+		{target: "sn:cn:yrk:0a1b2c:function:hello_world:1", valid: true},
+		{target: "sn:cn:yrk:12345678:function:hello:v1.2", valid: true},
+		{target: "hello"},
+		{target: "fn-urn-1"},
+		{target: "sn:cn:yrk:12345678:function:hello"},
+		{target: "sn:cn:yrk:12345678:function:hello:"},
+		{target: "sn:cn:yrk::function:hello:$latest"},
+		{target: "sn:cn:yrk:12345678:func:hello:$latest"},
+		{target: "sn:us:yrk:12345678:function:hello:$latest"},
+		{target: "sn:cn:yrk:12345678:function:hello:$latest:extra"},
+		{target: "sn:cn:yrk:12345678:function:../hello:$latest"},
+		{target: "sn:cn:yrk:12345678:function:hello/invocations:$latest"},
+		{target: "sn:cn:yrk:12345678:function:hello:$other"},
+		{target: "sn:cn:yrk:12345678:function:hello:1?x=1"},
+	}
+	for _, tt := range tests {
+		err := ValidateTargets([]v1alpha1.RouteTarget{{Target: tt.target, Type: backend.YuanrongBackendType, Ratio: 1}})
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got error %v, want valid %v", tt.target, err, tt.valid)
+		}
+	}
+}
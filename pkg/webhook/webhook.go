@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/seveirbian/edgeserverless/pkg/apis/edgeserverless/v1alpha1"
+	listers "github.com/seveirbian/edgeserverless/pkg/client/listers/edgeserverless/v1alpha1"
+	"github.com/seveirbian/edgeserverless/pkg/validation"
+)
+
+const ValidatePath = "/validate-routes"
+
+// RouteValidator admits Route objects that pass validation.ValidateRouteSpec
+// and do not claim a uri already used by another Route.
+type RouteValidator struct {
+	routesLister listers.RouteLister
+}
+
+func NewRouteValidator(routesLister listers.RouteLister) *RouteValidator {
+	return &RouteValidator{
+		routesLister: routesLister,
+	}
+}
+
+func (v *RouteValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+	if err := v.validate(review.Request); err != nil {
+		glog.Infof("[webhook] deny %s %s/%s: %v", review.Request.Operation,
+			review.Request.Namespace, review.Request.Name, err)
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+
+	review.Response = response
+	review.Request = nil
+	out, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+func (v *RouteValidator) validate(req *admissionv1.AdmissionRequest) error {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return nil
+	}
+
+	route := &v1alpha1.Route{}
+	if err := json.Unmarshal(req.Object.Raw, route); err != nil {
+		return fmt.Errorf("decode route: %v", err)
+	}
+	if route.Namespace == "" {
+		route.Namespace = req.Namespace
+	}
+
+	if err := validation.ValidateRouteSpec(&route.Spec); err != nil {
+		return err
+	}
+	if err := validation.ValidateBackends(&route.Spec); err != nil {
+		return err
+	}
+
+	routes, err := v.routesLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("list routes: %v", err)
+	}
+	return validation.ValidateURIUnclaimed(route, routes)
+}